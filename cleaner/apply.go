@@ -0,0 +1,831 @@
+package cleaner
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// applyCounts 统计一个文件中实际修改的键和记录
+type applyCounts struct {
+	updatedKeys   int
+	deletedKeys   int
+	deletedRows   int
+	clearedValues int
+}
+
+func (c applyCounts) records() int {
+	return c.deletedRows + c.clearedValues
+}
+
+// ApplyPlan carries out plan: backs up, modifies and clears exactly what the plan lists, phase by phase.
+// 返回每个阶段的结果；任一阶段失败时同时返回由各 PhaseError 组成的错误。计划无法执行时结果为 nil
+func (e *Engine) ApplyPlan(ctx context.Context, plan *Plan) (result *CleanResult, err error) {
+	appName := plan.AppName
+
+	e.sendProgress(ProgressUpdate{
+		Type:     "start",
+		Message:  e.localizeMessage("StartReset", map[string]interface{}{"AppName": appName}),
+		AppName:  appName,
+		Progress: 0,
+	})
+
+	if err := e.validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	// Safety checks
+	if e.config.SafetyOptions.CheckRunningProcesses {
+		if e.IsAppRunning(appName) {
+			return nil, fmt.Errorf(e.localizeMessage("AppRunning", map[string]interface{}{"AppName": appName}))
+		}
+	}
+
+	// 没有可用的备份目录时不做任何修改；dry-run 不写入任何文件，也不需要备份目录
+	if !e.dryRun {
+		if err := e.CheckBackupDirectory(); err != nil {
+			return nil, err
+		}
+	}
+
+	phases, err := resolvePhases(plan.PhaseNames())
+	if err != nil {
+		return nil, err
+	}
+
+	// Clean old backups
+	e.cleanOldBackups()
+
+	if err := e.beginSession(appName); err != nil {
+		return nil, err
+	}
+	result = &CleanResult{AppName: appName, DryRun: e.dryRun}
+	session := e.session
+	defer func() {
+		e.finishSession()
+		// 没有任何备份的会话不会保存
+		if len(session.manifest.Items) > 0 {
+			result.SessionID = session.manifest.SessionID
+		}
+	}()
+
+	e.done, e.touched, e.current = nil, nil, nil
+	defer func() { e.current = nil }()
+
+	// 事务模式下运行失败或被取消时用本次的备份撤销所有修改；dry-run 不写入任何文件，无需撤销
+	transactional := e.config.SafetyOptions.Transactional && !e.dryRun
+	if transactional {
+		e.warnUnprotectedChanges(plan)
+	}
+	abort := func(err error) error {
+		if transactional {
+			return e.rollback(appName, err)
+		}
+		return err
+	}
+
+	progress := 20.0
+	for _, phase := range phases {
+		name := phase.Name()
+		if err := ctx.Err(); err != nil {
+			return result, abort(e.cancelled(appName, name, err))
+		}
+
+		result.Phases = append(result.Phases, PhaseResult{Name: name})
+		e.current = &result.Phases[len(result.Phases)-1]
+
+		message := e.localizeMessage("RunningPhase", map[string]interface{}{"Phase": name})
+		if start, builtin := phaseStart[name]; builtin {
+			message = e.localizeMessage(start.messageID, nil)
+			progress = start.progress
+		}
+		e.sendProgress(ProgressUpdate{
+			Type:     "phase",
+			Message:  message,
+			AppName:  appName,
+			Phase:    name,
+			Progress: progress,
+		})
+
+		if err := phase.Apply(ctx, e, plan, plan.PhaseActions(name)); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return result, abort(e.cancelled(appName, name, ctxErr))
+			}
+			log.Error().Err(err).Str("app", appName).Str("phase", name).Msg("Phase failed")
+			e.current.Err = err
+			if transactional || errors.Is(err, ErrBackupBudgetExceeded) {
+				return result, abort(result.Err())
+			}
+		}
+
+		if transactional && e.current.Failed() {
+			return result, abort(result.Err())
+		}
+	}
+
+	if err := result.Err(); err != nil {
+		e.sendProgress(ProgressUpdate{
+			Type:     "complete",
+			Message:  e.localizeMessage("ResetCompleteWithErrors", map[string]interface{}{"AppName": appName}),
+			AppName:  appName,
+			Progress: 100,
+		})
+		return result, err
+	}
+
+	e.sendProgress(ProgressUpdate{
+		Type:     "complete",
+		Message:  e.localizeMessage("ResetSuccess", map[string]interface{}{"AppName": appName}),
+		AppName:  appName,
+		Progress: 100,
+	})
+
+	return result, nil
+}
+
+// cancelled reports a reset stopped by its context together with the items it had already completed
+func (e *Engine) cancelled(appName, phase string, err error) error {
+	done := append([]string(nil), e.done...)
+	log.Warn().Str("app", appName).Str("phase", phase).Int("completed", len(done)).Msg("Reset cancelled")
+
+	e.sendProgress(ProgressUpdate{
+		Type:    "cancelled",
+		Message: e.localizeMessage("ResetCancelled", map[string]interface{}{"AppName": appName, "Count": len(done)}),
+		AppName: appName,
+		Phase:   phase,
+	})
+
+	return &CancelledError{AppName: appName, Phase: phase, Done: done, Err: err}
+}
+
+// groupByPath 按路径分组，保持计划中的顺序
+func groupByPath(actions []Action) [][]Action {
+	var groups [][]Action
+	index := make(map[string]int)
+	for _, action := range actions {
+		i, exists := index[action.Path]
+		if !exists {
+			i = len(groups)
+			index[action.Path] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], action)
+	}
+	return groups
+}
+
+// backupPaths returns the paths backed up by actions, 用于检查备份预算
+func backupPaths(actions []Action) []string {
+	var paths []string
+	for _, action := range actions {
+		if action.Type == ActionBackup {
+			paths = append(paths, action.Path)
+		}
+	}
+	return paths
+}
+
+// applyBackups runs the backup actions of a path and reports whether its other actions may run
+func (e *Engine) applyBackups(group []Action) bool {
+	for _, action := range group {
+		if action.Type == ActionBackup && !e.backupBeforeModify(action.Phase, action.Path, action.BackupName) {
+			return false
+		}
+	}
+	return true
+}
+
+// changeActions 返回除备份以外的动作
+func changeActions(group []Action) []Action {
+	var changes []Action
+	for _, action := range group {
+		if action.Type != ActionBackup {
+			changes = append(changes, action)
+		}
+	}
+	return changes
+}
+
+// applyTelemetry writes the planned telemetry IDs and removes the planned session keys
+func (e *Engine) applyTelemetry(ctx context.Context, appName string, actions []Action) error {
+	groups := groupByPath(actions)
+
+	// 处理结果统计
+	var (
+		processedFiles  int
+		updatedKeys     int
+		deletedKeys     int
+		failedFiles     int
+		totalFoundFiles = len(groups)
+	)
+
+	if err := e.checkBackupBudget(PhaseTelemetry, backupPaths(actions)); err != nil {
+		return err
+	}
+
+	// 发送开始处理文件的消息
+	e.sendProgress(ProgressUpdate{
+		Type:     "telemetry",
+		Message:  e.localizeMessage("ProcessingStartFiles", map[string]interface{}{"Count": totalFoundFiles}),
+		Phase:    PhaseTelemetry,
+		Progress: 20,
+		AppName:  appName,
+	})
+
+	for fileIndex, group := range groups {
+		// 只在文件之间停止，正在处理的文件总是完整处理或保持原样
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		filePath := group[0].Path
+
+		progress := 22.0 + float64(fileIndex)*18.0/float64(totalFoundFiles+1)
+		e.sendProgress(ProgressUpdate{
+			Type: "telemetry",
+			Message: e.localizeMessage("ProcessingFile", map[string]interface{}{
+				"Current":  fileIndex + 1,
+				"Total":    totalFoundFiles,
+				"FileName": filepath.Base(filePath),
+			}),
+			Phase:    PhaseTelemetry,
+			Progress: progress,
+			AppName:  appName,
+		})
+
+		// 检查文件是否存在和可访问
+		if _, err := os.Stat(filePath); os.IsNotExist(err) {
+			log.Warn().Str("file", filePath).Msg("File does not exist, skipping")
+			e.recordFailure(filePath, ErrFileMissing, nil)
+			failedFiles++
+			continue
+		}
+
+		if !e.applyBackups(group) {
+			e.recordFailure(filePath, ErrBackupFailed, nil)
+			failedFiles++
+			continue
+		}
+
+		e.markTouched(filePath)
+		var counts applyCounts
+		applyErr := e.withDryRunCopy(filePath, func(target string) (err error) {
+			if isJSONFileName(filePath) {
+				counts, err = e.applyJSONActions(target, changeActions(group))
+			} else {
+				counts, err = e.applySQLiteActions(ctx, target, changeActions(group))
+			}
+			return err
+		})
+		if err := ctx.Err(); err != nil && applyErr != nil {
+			return err
+		}
+
+		// 更新统计信息
+		processedFiles++
+		updatedKeys += counts.updatedKeys
+		deletedKeys += counts.deletedKeys
+		e.current.FilesProcessed++
+		e.current.KeysUpdated += counts.updatedKeys
+		e.current.KeysDeleted += counts.deletedKeys
+		if applyErr != nil {
+			e.recordFailure(filePath, ErrModifyFailed, applyErr)
+			failedFiles++
+		} else if !e.dryRun {
+			e.markDone(filePath)
+		}
+
+		fileUpdated := counts.updatedKeys > 0 || counts.deletedKeys > 0
+		if fileUpdated && e.dryRun {
+			log.Info().Str("file", filePath).Int("updated_keys", counts.updatedKeys).Int("deleted_keys", counts.deletedKeys).Msg("Would modify identifier file")
+		} else if fileUpdated {
+			log.Info().Str("file", filePath).Int("updated_keys", counts.updatedKeys).Int("deleted_keys", counts.deletedKeys).Msg("Successfully modified identifier file")
+		}
+	}
+
+	// 发送标识符修改完成消息
+	e.sendProgress(ProgressUpdate{
+		Type: "telemetry",
+		Message: e.localizeMessage("TelemetryModificationComplete", map[string]interface{}{
+			"Processed": processedFiles,
+			"Updated":   updatedKeys,
+			"Deleted":   deletedKeys,
+			"Failed":    failedFiles,
+		}),
+		Phase:    PhaseTelemetry,
+		Progress: 45,
+		AppName:  appName,
+	})
+
+	return nil
+}
+
+// applyDatabases removes the planned rows and column values from each database
+func (e *Engine) applyDatabases(ctx context.Context, appName string, actions []Action) error {
+	groups := groupByPath(actions)
+	totalFiles := len(groups)
+
+	if totalFiles == 0 {
+		log.Warn().Str("app", appName).Msg("没有需要重置的数据库")
+		e.sendProgress(ProgressUpdate{
+			Type:     "database",
+			Message:  e.localizeMessage("NoDatabaseFound", nil),
+			AppName:  appName,
+			Phase:    PhaseDatabase,
+			Progress: 65,
+		})
+		return nil
+	}
+
+	e.sendProgress(ProgressUpdate{
+		Type:     "database",
+		Message:  e.localizeMessage("FoundDatabases", map[string]interface{}{"Count": totalFiles}),
+		AppName:  appName,
+		Phase:    PhaseDatabase,
+		Progress: 50,
+	})
+
+	if err := e.checkBackupBudget(PhaseDatabase, backupPaths(actions)); err != nil {
+		return err
+	}
+
+	// 跟踪处理结果
+	var (
+		processedFiles int
+		cleanedFiles   int
+		totalRecords   int
+		failedFiles    int
+	)
+
+	for fileIndex, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		dbPath := group[0].Path
+
+		progress := 50.0 + float64(fileIndex)*15.0/float64(totalFiles+1)
+		e.sendProgress(ProgressUpdate{
+			Type: "database",
+			Message: e.localizeMessage("ProcessingDatabase", map[string]interface{}{
+				"Current":  fileIndex + 1,
+				"Total":    totalFiles,
+				"FileName": filepath.Base(dbPath),
+			}),
+			AppName:  appName,
+			Phase:    PhaseDatabase,
+			Progress: progress,
+		})
+
+		if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+			log.Warn().Str("file", dbPath).Msg("File does not exist, skipping")
+			e.recordFailure(dbPath, ErrFileMissing, nil)
+			failedFiles++
+			continue
+		}
+
+		if !e.applyBackups(group) {
+			e.recordFailure(dbPath, ErrBackupFailed, nil)
+			failedFiles++
+			continue
+		}
+
+		e.markTouched(dbPath)
+		var counts applyCounts
+		applyErr := e.withDryRunCopy(dbPath, func(target string) (err error) {
+			counts, err = e.applySQLiteActions(ctx, target, changeActions(group))
+			return err
+		})
+		if err := ctx.Err(); err != nil && applyErr != nil {
+			return err
+		}
+
+		// 更新统计
+		processedFiles++
+		recordsAffected := counts.records()
+		if recordsAffected > 0 {
+			cleanedFiles++
+			totalRecords += recordsAffected
+		}
+		e.current.FilesProcessed++
+		e.current.RowsRemoved += counts.deletedRows
+		e.current.ValuesCleared += counts.clearedValues
+		if applyErr != nil {
+			e.recordFailure(dbPath, ErrModifyFailed, applyErr)
+			failedFiles++
+		} else if !e.dryRun {
+			e.markDone(dbPath)
+		}
+
+		if recordsAffected > 0 && e.dryRun {
+			log.Info().Str("file", dbPath).Int("records_affected", recordsAffected).Msg("将重置数据库（dry-run）")
+		} else if recordsAffected > 0 {
+			log.Info().Str("file", dbPath).Int("records_affected", recordsAffected).Msg("成功重置数据库")
+		}
+	}
+
+	// 发送完成消息
+	e.sendProgress(ProgressUpdate{
+		Type: "database",
+		Message: e.localizeMessage("DatabaseResetComplete", map[string]interface{}{
+			"Reset":   cleanedFiles,
+			"Total":   processedFiles,
+			"Records": totalRecords,
+			"Failed":  failedFiles,
+		}),
+		AppName:  appName,
+		Phase:    PhaseDatabase,
+		Progress: 65,
+	})
+
+	return nil
+}
+
+// applySQLiteActions runs the changes planned for one database in a single transaction.
+// 在语句之间检查 ctx，取消或任一语句失败时回滚事务并返回错误，数据库保持原样
+func (e *Engine) applySQLiteActions(ctx context.Context, dbPath string, actions []Action) (applyCounts, error) {
+	var counts applyCounts
+
+	db, err := openSQLite(dbPath)
+	if err != nil {
+		log.Error().Str("path", dbPath).Err(err).Msg("Failed to open database")
+		return counts, err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Error().Str("path", dbPath).Err(err).Msg("Failed to begin transaction")
+		return counts, err
+	}
+
+	for _, action := range actions {
+		if ctx.Err() != nil {
+			tx.Rollback()
+			log.Info().Str("path", dbPath).Msg("Reset cancelled, database left unchanged")
+			return applyCounts{}, ctx.Err()
+		}
+		table := quoteIdentifier(action.Table)
+
+		switch action.Type {
+		case ActionUpdateKey:
+			updateSQL := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?", table, quoteIdentifier(action.ValueColumn), quoteIdentifier(action.KeyColumn))
+			affected, err := execAffected(tx, updateSQL, action.Value, action.Key)
+			if err != nil {
+				return rollbackSQLite(tx, dbPath, action.Table, err)
+			}
+			if affected > 0 {
+				counts.updatedKeys++
+				log.Debug().Str("table", action.Table).Str("key", action.Key).Msg("Successfully updated key")
+			}
+
+		case ActionDeleteKey:
+			deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE %s = ?", table, quoteIdentifier(action.KeyColumn))
+			affected, err := execAffected(tx, deleteSQL, action.Key)
+			if err != nil {
+				return rollbackSQLite(tx, dbPath, action.Table, err)
+			}
+			if affected > 0 {
+				counts.deletedKeys++
+				log.Debug().Str("table", action.Table).Str("key", action.Key).Msg("Successfully deleted key")
+			}
+
+		case ActionDeleteRows:
+			// 没有指定列时清空整个缓存表
+			if len(action.Columns) == 0 {
+				affected, err := execAffected(tx, fmt.Sprintf("DELETE FROM %s", table))
+				if err != nil {
+					return rollbackSQLite(tx, dbPath, action.Table, err)
+				}
+				counts.deletedRows += int(affected)
+				log.Info().Str("table", action.Table).Int64("records", affected).Msg("清空表成功")
+				continue
+			}
+
+			for _, keyword := range action.Keywords {
+				for _, column := range action.Columns {
+					deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE %s LIKE ?", table, quoteIdentifier(column))
+					affected, err := execAffected(tx, deleteSQL, "%"+keyword+"%")
+					if err != nil {
+						return rollbackSQLite(tx, dbPath, action.Table, err)
+					}
+					if affected > 0 {
+						counts.deletedRows += int(affected)
+						log.Info().Str("table", action.Table).Str("column", column).Str("keyword", keyword).Int64("records", affected).Msg("按关键词删除记录成功")
+					}
+				}
+			}
+
+		case ActionClearColumn:
+			column := quoteIdentifier(action.Column)
+			// 尝试将字段设为NULL，NOT NULL 列改为清空
+			result, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s IS NOT NULL", table, column, column))
+			if err != nil {
+				log.Debug().Str("table", action.Table).Str("column", action.Column).Err(err).Msg("设置列为NULL失败，尝试清空")
+				result, err = tx.Exec(fmt.Sprintf("UPDATE %s SET %s = '' WHERE %s != ''", table, column, column))
+			}
+			if err != nil {
+				return rollbackSQLite(tx, dbPath, action.Table, err)
+			}
+			if affected, err := result.RowsAffected(); err == nil && affected > 0 {
+				counts.clearedValues += int(affected)
+				log.Info().Str("table", action.Table).Str("column", action.Column).Int64("records", affected).Msg("重置用户相关列成功")
+			}
+		}
+	}
+
+	// 提交事务
+	if err := tx.Commit(); err != nil {
+		log.Error().Str("path", dbPath).Err(err).Msg("Failed to commit transaction")
+		return applyCounts{}, err
+	}
+
+	// 如果有更改，执行VACUUM
+	if counts.updatedKeys > 0 || counts.deletedKeys > 0 || counts.records() > 0 {
+		if _, err := db.Exec("VACUUM"); err != nil {
+			log.Warn().Str("path", dbPath).Err(err).Msg("Failed to execute VACUUM")
+		}
+	}
+
+	return counts, nil
+}
+
+// execAffected 执行语句并返回受影响的行数
+func execAffected(tx *sql.Tx, query string, args ...interface{}) (int64, error) {
+	result, err := tx.Exec(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// rollbackSQLite 语句失败时回滚整个事务，数据库保持修改前的状态
+func rollbackSQLite(tx *sql.Tx, dbPath, table string, err error) (applyCounts, error) {
+	if rollbackErr := tx.Rollback(); rollbackErr != nil {
+		log.Error().Str("path", dbPath).Err(rollbackErr).Msg("Failed to roll back transaction")
+	}
+	log.Error().Str("path", dbPath).Str("table", table).Err(err).Msg("Statement failed, database left unchanged")
+	return applyCounts{}, fmt.Errorf("table %s: %w", table, err)
+}
+
+// applyJSONActions replaces the planned keys of a JSON file at any depth and writes it back atomically
+func (e *Engine) applyJSONActions(jsonPath string, actions []Action) (applyCounts, error) {
+	var counts applyCounts
+
+	jsonData, err := readJSONObject(jsonPath)
+	if err != nil {
+		log.Error().Str("path", jsonPath).Err(err).Msg("读取JSON文件失败")
+		return counts, err
+	}
+	if jsonData == nil {
+		return counts, nil
+	}
+
+	updates := make(map[string]string)
+	deletes := make(map[string]bool)
+	for _, action := range actions {
+		switch action.Type {
+		case ActionUpdateKey:
+			updates[action.Key] = action.Value
+		case ActionDeleteKey:
+			deletes[action.Key] = true
+		}
+	}
+
+	updated := make(map[string]int64)
+	deleted := make(map[string]int64)
+	rewriteJSON(jsonData, updates, deletes, updated, deleted)
+	for _, count := range updated {
+		counts.updatedKeys += int(count)
+	}
+	for _, count := range deleted {
+		counts.deletedKeys += int(count)
+	}
+
+	if counts.updatedKeys == 0 && counts.deletedKeys == 0 {
+		log.Debug().Str("path", jsonPath).Msg("JSON文件无需修改")
+		return counts, nil
+	}
+
+	newData, err := json.MarshalIndent(jsonData, "", "  ")
+	if err != nil {
+		log.Error().Str("path", jsonPath).Err(err).Msg("JSON序列化失败")
+		return applyCounts{}, err
+	}
+
+	// 写入临时文件后重命名替换原文件，失败时原文件保持不变
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(jsonPath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tempFilePath := jsonPath + ".tmp"
+	if err := os.WriteFile(tempFilePath, newData, mode); err != nil {
+		log.Error().Str("path", tempFilePath).Err(err).Msg("写入临时文件失败")
+		os.Remove(tempFilePath)
+		return applyCounts{}, err
+	}
+	os.Chmod(tempFilePath, mode)
+
+	if err := os.Rename(tempFilePath, jsonPath); err != nil {
+		log.Error().Str("from", tempFilePath).Str("to", jsonPath).Err(err).Msg("重命名文件失败")
+		os.Remove(tempFilePath)
+		return applyCounts{}, err
+	}
+
+	log.Info().Str("path", jsonPath).Int("updated_keys", counts.updatedKeys).Int("deleted_keys", counts.deletedKeys).Msg("成功更新JSON文件")
+	return counts, nil
+}
+
+// applyCache clears the planned cache directories
+func (e *Engine) applyCache(ctx context.Context, appName string, actions []Action) error {
+	// 只备份而不清空的目录没有意义，跳过
+	var groups [][]Action
+	for _, group := range groupByPath(actions) {
+		if _, ok := clearDirAction(group); ok {
+			groups = append(groups, group)
+		}
+	}
+
+	if len(groups) == 0 {
+		log.Warn().Str("app", appName).Msg("No cache directories found")
+		e.sendProgress(ProgressUpdate{
+			Type:     "cache",
+			Message:  e.localizeMessage("NoCacheFound", nil),
+			AppName:  appName,
+			Phase:    PhaseCache,
+			Progress: 100,
+		})
+		return nil
+	}
+
+	if err := e.checkBackupBudget(PhaseCache, backupPaths(actions)); err != nil {
+		return err
+	}
+
+	// 开始重置缓存目录
+	e.sendProgress(ProgressUpdate{
+		Type:     "cache",
+		Message:  e.localizeMessage("StartingCacheReset", map[string]interface{}{"Count": len(groups)}),
+		AppName:  appName,
+		Phase:    PhaseCache,
+		Progress: 85,
+	})
+
+	// 每种缓存目录的统计信息，按计划中出现的顺序报告
+	stats := make(map[string]*CacheStats)
+	var cacheDirs []string
+	for _, group := range groups {
+		action, _ := clearDirAction(group)
+		dirName := action.CacheDir
+		if stats[dirName] == nil {
+			stats[dirName] = &CacheStats{}
+			cacheDirs = append(cacheDirs, dirName)
+		}
+		stats[dirName].DirCount++
+	}
+
+	seen := make(map[string]int)
+	for i, group := range groups {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		action, _ := clearDirAction(group)
+		dir, dirName := action.Path, action.CacheDir
+
+		if seen[dirName] == 0 {
+			e.sendProgress(ProgressUpdate{
+				Type: "cache",
+				Message: e.localizeMessage("CacheDirectoryFound", map[string]interface{}{
+					"DirName": dirName,
+					"Count":   stats[dirName].DirCount,
+				}),
+				AppName:  appName,
+				Phase:    PhaseCache,
+				Progress: 85 + float64(i)*15.0/float64(len(groups)),
+			})
+		}
+		seen[dirName]++
+
+		// 上层缓存目录被清空后，嵌套在其中的目录可能已不存在
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+
+		e.sendProgress(ProgressUpdate{
+			Type: "cache",
+			Message: e.localizeMessage("ResettingCacheDirectory", map[string]interface{}{
+				"DirName":     dirName,
+				"Current":     seen[dirName],
+				"Total":       stats[dirName].DirCount,
+				"DirBaseName": filepath.Base(dir),
+			}),
+			AppName:  appName,
+			Phase:    PhaseCache,
+			Progress: 85 + float64(i)*15.0/float64(len(groups)),
+		})
+
+		sizeBefore := e.GetDirectorySize(dir)
+		stats[dirName].TotalSize += sizeBefore
+
+		if !e.applyBackups(group) {
+			e.recordFailure(dir, ErrBackupFailed, nil)
+			continue
+		}
+
+		if e.dryRun {
+			log.Info().Str("dir", dir).Str("size", e.FormatSize(sizeBefore)).Msg("Would clear cache directory")
+			stats[dirName].CleanedDirs++
+			e.current.BytesFreed += sizeBefore
+			continue
+		}
+
+		e.markTouched(dir)
+		if err := e.clearDirectoryContents(ctx, dir); ctx.Err() != nil {
+			log.Warn().Str("dir", dir).Msg("Reset cancelled, cache directory partially cleared")
+			return ctx.Err()
+		} else if err != nil {
+			e.recordFailure(dir, ErrClearFailed, err)
+			log.Error().Str("dir", dir).Err(err).Msg("Failed to clear cache directory")
+		} else {
+			stats[dirName].CleanedDirs++
+			e.markDone(dir)
+			log.Info().Str("dir", dir).Str("size_freed", e.FormatSize(sizeBefore)).Msg("Cleared cache directory")
+		}
+
+		// 验证重置结果
+		sizeAfter := e.GetDirectorySize(dir)
+		remaining := sizeAfter
+		if sizeAfter > 0 {
+			log.Warn().Str("dir", dir).Str("remaining_size", e.FormatSize(sizeAfter)).Msg("Directory not completely cleared")
+
+			// 尝试再次重置
+			log.Info().Str("dir", dir).Msg("Attempting second cleanup pass")
+			if err := e.clearDirectoryContents(ctx, dir); ctx.Err() != nil {
+				return ctx.Err()
+			} else if err != nil {
+				log.Error().Str("dir", dir).Err(err).Msg("Failed second cleanup attempt")
+			} else if remaining = e.GetDirectorySize(dir); remaining < sizeAfter {
+				log.Info().
+					Str("dir", dir).
+					Str("before", e.FormatSize(sizeAfter)).
+					Str("after", e.FormatSize(remaining)).
+					Msg("Second cleanup pass improved results")
+			}
+		}
+		if remaining < sizeBefore {
+			e.current.BytesFreed += sizeBefore - remaining
+		}
+	}
+
+	// 生成并记录总结报告
+	var totalSize int64
+	var totalCleanedDirs int
+
+	for _, dirName := range cacheDirs {
+		stat := stats[dirName]
+		log.Info().
+			Str("directory_type", dirName).
+			Int("directories", stat.DirCount).
+			Int("cleaned", stat.CleanedDirs).
+			Str("size_freed", e.FormatSize(stat.TotalSize)).
+			Msgf("Cache stats: %s", dirName)
+
+		totalSize += stat.TotalSize
+		totalCleanedDirs += stat.CleanedDirs
+		e.current.CacheDirs = append(e.current.CacheDirs, CacheDirStats{Name: dirName, CacheStats: *stat})
+	}
+	e.current.DirsCleared = totalCleanedDirs
+
+	log.Info().
+		Str("app", appName).
+		Int("directories_cleaned", totalCleanedDirs).
+		Str("total_size_freed", e.FormatSize(totalSize)).
+		Msg("Total cache cleaning results")
+
+	// 发送最终的完成进度
+	e.sendProgress(ProgressUpdate{
+		Type: "cache",
+		Message: e.localizeMessage("CacheResetComplete", map[string]interface{}{
+			"DirCount": totalCleanedDirs,
+			"Size":     e.FormatSize(totalSize),
+		}),
+		AppName:  appName,
+		Phase:    PhaseCache,
+		Progress: 100,
+	})
+
+	return nil
+}
+
+// clearDirAction returns the clear_dir action of a cache directory group
+func clearDirAction(group []Action) (Action, bool) {
+	for _, action := range group {
+		if action.Type == ActionClearDir {
+			return action, true
+		}
+	}
+	return Action{}, false
+}
@@ -0,0 +1,437 @@
+package cleaner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+
+	"Cursor_Windsurf_Reset/config"
+)
+
+// zip 条目的创建者为 Unix 时才带有 FileInfoHeader 写入的权限信息，旧版本用 zipWriter.Create 创建的备份没有
+const zipCreatorUnix = 3
+
+// backupFormat returns the configured backup format.
+// 未设置 Format 时沿用旧的 Compression 选项，兼容已有配置文件
+func (e *Engine) backupFormat() (string, error) {
+	switch format := e.config.BackupOptions.Format; format {
+	case "":
+		if e.config.BackupOptions.Compression {
+			return config.BackupFormatZip, nil
+		}
+		return config.BackupFormatDirectory, nil
+	case config.BackupFormatDirectory, config.BackupFormatZip, config.BackupFormatTarGz, config.BackupFormatTarZst, config.BackupFormatStore:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported backup format: %s", format)
+	}
+}
+
+// backupExtension returns the file extension of backups written in format
+func backupExtension(format string) string {
+	switch format {
+	case config.BackupFormatZip:
+		return ".zip"
+	case config.BackupFormatTarGz:
+		return ".tar.gz"
+	case config.BackupFormatTarZst:
+		return ".tar.zst"
+	}
+	return ""
+}
+
+func isSymlink(mode os.FileMode) bool {
+	return mode&os.ModeSymlink != 0
+}
+
+// walkBackupSource calls fn for every entry to archive below sourcePath, without following symlinks.
+// name 为使用 / 分隔的相对路径，单个文件备份时为文件名；套接字、管道等特殊文件会被跳过
+func walkBackupSource(sourcePath string, fn func(path, name string, info os.FileInfo) error) error {
+	sourceInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+	if !sourceInfo.IsDir() {
+		return fn(sourcePath, filepath.Base(sourcePath), sourceInfo)
+	}
+
+	return filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == sourcePath {
+			return nil
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() && !isSymlink(info.Mode()) {
+			log.Debug().Str("path", path).Msg("Skipping special file")
+			return nil
+		}
+
+		relPath, err := filepath.Rel(sourcePath, path)
+		if err != nil {
+			return err
+		}
+
+		return fn(path, filepath.ToSlash(relPath), info)
+	})
+}
+
+// applyFileMetadata restores the permissions and modification time of a restored file or directory
+func applyFileMetadata(path string, mode os.FileMode, modTime time.Time) {
+	if mode != 0 {
+		if err := os.Chmod(path, mode.Perm()); err != nil {
+			log.Warn().Str("path", path).Err(err).Msg("Failed to restore file mode")
+		}
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			log.Warn().Str("path", path).Err(err).Msg("Failed to restore file modification time")
+		}
+	}
+}
+
+// treeWriter 在复制或解压目录树时延后处理目录元数据和符号链接：
+// 写入子项会改变目录的修改时间，只读目录也无法再写入；先创建的符号链接还可能被后续条目写穿
+type treeWriter struct {
+	dirs  []treeDir
+	links []treeLink
+}
+
+type treeDir struct {
+	path    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+type treeLink struct {
+	path   string
+	target string
+}
+
+func (w *treeWriter) addDir(path string, mode os.FileMode, modTime time.Time) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	w.dirs = append(w.dirs, treeDir{path, mode, modTime})
+	return nil
+}
+
+func (w *treeWriter) addSymlink(path, target string) {
+	w.links = append(w.links, treeLink{path, target})
+}
+
+// finish creates the symlinks and applies directory metadata, deepest directories first
+func (w *treeWriter) finish() error {
+	for _, link := range w.links {
+		if err := os.MkdirAll(filepath.Dir(link.path), 0755); err != nil {
+			return err
+		}
+		if _, err := os.Lstat(link.path); err == nil {
+			if err := os.RemoveAll(link.path); err != nil {
+				return err
+			}
+		}
+		if err := os.Symlink(link.target, link.path); err != nil {
+			return err
+		}
+	}
+
+	for i := len(w.dirs) - 1; i >= 0; i-- {
+		applyFileMetadata(w.dirs[i].path, w.dirs[i].mode, w.dirs[i].modTime)
+	}
+	return nil
+}
+
+// writeFileFrom writes src to path with the given permissions and modification time
+func writeFileFrom(path string, src io.Reader, mode os.FileMode, modTime time.Time) error {
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	applyFileMetadata(path, mode, modTime)
+	return nil
+}
+
+// restoreBackup copies or extracts a backup in the given format to target
+func restoreBackup(backupPath, target string, isDir bool, format string) error {
+	switch format {
+	case config.BackupFormatZip:
+		return extractZipBackup(backupPath, target, isDir)
+	case config.BackupFormatTarGz, config.BackupFormatTarZst:
+		return extractTarBackup(backupPath, target, isDir, format)
+	}
+
+	if isDir {
+		return copyDirectory(backupPath, target)
+	}
+	return copyFile(backupPath, target)
+}
+
+// ---- zip ----
+
+// zipHasMetadata reports whether a zip entry carries Unix mode bits and a modification time
+func zipHasMetadata(entry *zip.File) bool {
+	return entry.CreatorVersion>>8 == zipCreatorUnix
+}
+
+// extractZipBackup extracts a backup created by createCompressedBackup to target
+func extractZipBackup(zipPath, target string, isDir bool) error {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if !isDir {
+		if len(reader.File) != 1 {
+			return fmt.Errorf("unexpected file backup archive with %d entries: %s", len(reader.File), zipPath)
+		}
+		return extractZipEntry(reader.File[0], target)
+	}
+
+	var tree treeWriter
+	for _, entry := range reader.File {
+		destPath, err := safeJoin(target, entry.Name)
+		if err != nil {
+			return err
+		}
+
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			var modTime time.Time
+			if !zipHasMetadata(entry) {
+				mode = 0
+			} else {
+				modTime = entry.Modified
+			}
+			if err := tree.addDir(destPath, mode, modTime); err != nil {
+				return err
+			}
+		case zipHasMetadata(entry) && isSymlink(mode):
+			linkTarget, err := readZipEntry(entry)
+			if err != nil {
+				return err
+			}
+			tree.addSymlink(destPath, string(linkTarget))
+		default:
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				return err
+			}
+			if err := extractZipEntry(entry, destPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tree.finish()
+}
+
+func readZipEntry(entry *zip.File) ([]byte, error) {
+	src, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	return io.ReadAll(src)
+}
+
+func extractZipEntry(entry *zip.File, destPath string) error {
+	src, err := entry.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// 旧格式的备份没有权限信息，按默认权限创建
+	if !zipHasMetadata(entry) {
+		return writeFileFrom(destPath, src, 0644, time.Time{})
+	}
+	return writeFileFrom(destPath, src, entry.Mode(), entry.Modified)
+}
+
+// ---- tar ----
+
+// createTarBackup writes sourcePath to a gzip or zstd compressed tar archive.
+// 使用 PAX 格式，保留纳秒级修改时间、权限、符号链接和空目录
+func (e *Engine) createTarBackup(sourcePath, backupPath, format string) (string, error) {
+	file, err := os.OpenFile(backupPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
+	if err != nil {
+		return "", err
+	}
+
+	compressor, err := newTarCompressor(file, format)
+	if err != nil {
+		file.Close()
+		os.Remove(backupPath)
+		return "", err
+	}
+	tarWriter := tar.NewWriter(compressor)
+
+	err = walkBackupSource(sourcePath, func(path, name string, info os.FileInfo) error {
+		var linkTarget string
+		if isSymlink(info.Mode()) {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			linkTarget = target
+		}
+
+		header, err := tar.FileInfoHeader(info, linkTarget)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		header.Format = tar.FormatPAX
+
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		src, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		_, err = io.Copy(tarWriter, src)
+		return err
+	})
+
+	// 依次关闭 tar、压缩流和文件，任何一步失败都说明归档不完整
+	for _, closer := range []io.Closer{tarWriter, compressor, file} {
+		if closeErr := closer.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		os.Remove(backupPath)
+		return "", err
+	}
+
+	log.Info().Str("path", backupPath).Str("format", format).Msg("Created archive backup")
+	return backupPath, nil
+}
+
+func newTarCompressor(w io.Writer, format string) (io.WriteCloser, error) {
+	switch format {
+	case config.BackupFormatTarGz:
+		return gzip.NewWriter(w), nil
+	case config.BackupFormatTarZst:
+		return zstd.NewWriter(w)
+	}
+	return nil, fmt.Errorf("unsupported backup format: %s", format)
+}
+
+func newTarDecompressor(r io.Reader, format string) (io.ReadCloser, error) {
+	switch format {
+	case config.BackupFormatTarGz:
+		return gzip.NewReader(r)
+	case config.BackupFormatTarZst:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unsupported backup format: %s", format)
+}
+
+// readTarBackup opens a tar backup and calls fn for every entry in order
+func readTarBackup(archivePath, format string, fn func(header *tar.Header, reader *tar.Reader) error) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	decompressor, err := newTarDecompressor(file, format)
+	if err != nil {
+		return err
+	}
+	defer decompressor.Close()
+
+	tarReader := tar.NewReader(decompressor)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(header, tarReader); err != nil {
+			return err
+		}
+	}
+}
+
+// extractTarBackup extracts a backup created by createTarBackup to target
+func extractTarBackup(archivePath, target string, isDir bool, format string) error {
+	if !isDir {
+		extracted := false
+		err := readTarBackup(archivePath, format, func(header *tar.Header, reader *tar.Reader) error {
+			if extracted || header.Typeflag != tar.TypeReg {
+				return fmt.Errorf("unexpected entry %s in file backup archive: %s", header.Name, archivePath)
+			}
+			extracted = true
+			return writeFileFrom(target, reader, header.FileInfo().Mode(), header.ModTime)
+		})
+		if err == nil && !extracted {
+			err = fmt.Errorf("file backup archive is empty: %s", archivePath)
+		}
+		return err
+	}
+
+	var tree treeWriter
+	err := readTarBackup(archivePath, format, func(header *tar.Header, reader *tar.Reader) error {
+		destPath, err := safeJoin(target, header.Name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			return tree.addDir(destPath, header.FileInfo().Mode(), header.ModTime)
+		case tar.TypeSymlink:
+			tree.addSymlink(destPath, header.Linkname)
+			return nil
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				return err
+			}
+			return writeFileFrom(destPath, reader, header.FileInfo().Mode(), header.ModTime)
+		default:
+			log.Debug().Str("entry", header.Name).Msg("Skipping unsupported archive entry")
+			return nil
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return tree.finish()
+}
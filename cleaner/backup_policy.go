@@ -0,0 +1,38 @@
+package cleaner
+
+import (
+	"Cursor_Windsurf_Reset/config"
+)
+
+// backupPolicy returns the backup policy for phase.
+// cacheDir 为缓存阶段匹配到的 cache_directories 条目，其策略优先于阶段策略；没有配置时总是备份
+func (e *Engine) backupPolicy(phase, cacheDir string) config.BackupPolicy {
+	options := e.config.CleaningOptions
+	if cacheDir != "" {
+		if policy, ok := options.CacheBackupPolicies[cacheDir]; ok {
+			return policy
+		}
+	}
+	if policy, ok := options.PhaseBackupPolicies[phase]; ok {
+		return policy
+	}
+	return config.BackupPolicy{Mode: config.BackupPolicyAlways}
+}
+
+// wantsBackup reports whether path is backed up before phase modifies it
+func (e *Engine) wantsBackup(phase, cacheDir, path string) bool {
+	policy := e.backupPolicy(phase, cacheDir)
+
+	switch policy.Mode {
+	case config.BackupPolicyAlways, "":
+		return true
+	case config.BackupPolicyNever:
+		return false
+	case config.BackupPolicySizeLimit:
+		return e.GetDirectorySize(path) <= int64(policy.MaxSizeMB)*1024*1024
+	}
+
+	// 无法识别的策略按最安全的方式处理
+	log.Warn().Str("phase", phase).Str("cache_dir", cacheDir).Str("mode", policy.Mode).Msg("Unknown backup policy, backing up")
+	return true
+}
@@ -0,0 +1,253 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// BackupSessionInfo summarizes a backup session for listing and pruning
+type BackupSessionInfo struct {
+	ID        string    `json:"id"`
+	AppName   string    `json:"app_name"`
+	CreatedAt time.Time `json:"created_at"`
+	Items     int       `json:"items"`
+	Size      int64     `json:"size"`      // 备份内容的原始大小
+	DiskSize  int64     `json:"disk_size"` // 会话目录占用的磁盘空间，不含去重存储中的对象
+	Dir       string    `json:"dir"`
+}
+
+// PruneOptions selects the backup sessions PruneBackupSessions removes. 零值表示不按该条件清理
+type PruneOptions struct {
+	KeepLast  int           // 每个应用保留的最新会话数
+	MaxSize   int64         // 备份目录总大小上限（字节），超出时从最旧的会话开始删除
+	OlderThan time.Duration // 删除早于该时长创建的会话
+}
+
+// backupSessionEntry 会话信息及其引用的去重对象，用于估算删除会话能释放的空间
+type backupSessionEntry struct {
+	info    BackupSessionInfo
+	objects []string
+}
+
+// listBackupSessions reads every session below the backup directory, newest first
+func (e *Engine) listBackupSessions() ([]backupSessionEntry, error) {
+	appDirs, err := os.ReadDir(e.backupBaseDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var sessions []backupSessionEntry
+	for _, appDir := range appDirs {
+		if !appDir.IsDir() || appDir.Name() == backupStoreDirName {
+			continue
+		}
+
+		sessionDirs, err := os.ReadDir(filepath.Join(e.backupBaseDir, appDir.Name()))
+		if err != nil {
+			continue
+		}
+
+		for _, sessionDir := range sessionDirs {
+			dir := filepath.Join(e.backupBaseDir, appDir.Name(), sessionDir.Name())
+			if _, err := os.Stat(filepath.Join(dir, manifestFileName)); err != nil {
+				continue
+			}
+
+			manifest, err := readManifest(dir)
+			if err != nil {
+				log.Warn().Str("session", dir).Err(err).Msg("Failed to read backup manifest")
+				continue
+			}
+
+			entry := backupSessionEntry{
+				info: BackupSessionInfo{
+					ID:        manifest.SessionID,
+					AppName:   manifest.AppName,
+					CreatedAt: manifest.CreatedAt,
+					Items:     len(manifest.Items),
+					DiskSize:  e.GetDirectorySize(dir),
+					Dir:       dir,
+				},
+			}
+
+			seen := make(map[string]bool)
+			for _, item := range manifest.Items {
+				entry.info.Size += item.Size
+				for _, file := range item.Files {
+					if file.SHA256 != "" && !seen[file.SHA256] {
+						seen[file.SHA256] = true
+						entry.objects = append(entry.objects, file.SHA256)
+					}
+				}
+			}
+
+			sessions = append(sessions, entry)
+		}
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].info.CreatedAt.After(sessions[j].info.CreatedAt)
+	})
+
+	return sessions, nil
+}
+
+// ListBackupSessions returns every backup session, newest first
+func (e *Engine) ListBackupSessions() ([]BackupSessionInfo, error) {
+	sessions, err := e.listBackupSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]BackupSessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, session.info)
+	}
+	return infos, nil
+}
+
+// InspectBackupSession returns the manifest of a session given as "<id>" or "<app>/<id>"
+func (e *Engine) InspectBackupSession(sessionID string) (*Manifest, error) {
+	return e.loadManifest(sessionID)
+}
+
+// DeleteBackupSession removes a backup session and the store objects only it referenced
+func (e *Engine) DeleteBackupSession(sessionID string) error {
+	sessionDir, err := e.findSessionDir(sessionID)
+	if err != nil {
+		return err
+	}
+
+	if err := e.removeSessionDir(sessionDir); err != nil {
+		return err
+	}
+
+	e.collectStoreGarbage()
+	return nil
+}
+
+func (e *Engine) removeSessionDir(sessionDir string) error {
+	if e.dryRun {
+		log.Info().Str("session", sessionDir).Msg("Would remove backup session")
+		return nil
+	}
+
+	if err := os.RemoveAll(sessionDir); err != nil {
+		return err
+	}
+	log.Info().Str("session", sessionDir).Msg("Removed backup session")
+
+	// 删除已经没有会话的应用目录
+	os.Remove(filepath.Dir(sessionDir))
+	return nil
+}
+
+// SelectSessionsToPrune returns the sessions PruneBackupSessions would remove, oldest first
+func (e *Engine) SelectSessionsToPrune(opts PruneOptions) ([]BackupSessionInfo, error) {
+	sessions, err := e.listBackupSessions()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]bool, len(sessions))
+
+	if opts.OlderThan > 0 {
+		cutoff := time.Now().Add(-opts.OlderThan)
+		for i, session := range sessions {
+			if session.info.CreatedAt.Before(cutoff) {
+				selected[i] = true
+			}
+		}
+	}
+
+	if opts.KeepLast > 0 {
+		kept := make(map[string]int)
+		for i, session := range sessions {
+			if kept[session.info.AppName] >= opts.KeepLast {
+				selected[i] = true
+				continue
+			}
+			kept[session.info.AppName]++
+		}
+	}
+
+	if opts.MaxSize > 0 {
+		e.selectSessionsOverSize(sessions, selected, opts.MaxSize)
+	}
+
+	var result []BackupSessionInfo
+	for i := len(sessions) - 1; i >= 0; i-- {
+		if selected[i] {
+			result = append(result, sessions[i].info)
+		}
+	}
+	return result, nil
+}
+
+// selectSessionsOverSize selects the oldest sessions until the backup directory fits into maxSize.
+// 去重对象只有在最后一个引用它的会话被删除后才会释放，按引用计数估算
+func (e *Engine) selectSessionsOverSize(sessions []backupSessionEntry, selected []bool, maxSize int64) {
+	total := e.GetDirectorySize(e.backupBaseDir)
+	store := e.backupStore()
+
+	refs := make(map[string]int)
+	for _, session := range sessions {
+		for _, object := range session.objects {
+			refs[object]++
+		}
+	}
+
+	release := func(session backupSessionEntry) {
+		total -= session.info.DiskSize
+		for _, object := range session.objects {
+			refs[object]--
+			if refs[object] > 0 {
+				continue
+			}
+			if objectPath, err := store.objectPath(object); err == nil {
+				if info, err := os.Stat(objectPath); err == nil {
+					total -= info.Size()
+				}
+			}
+		}
+	}
+
+	for i, session := range sessions {
+		if selected[i] {
+			release(session)
+		}
+	}
+
+	for i := len(sessions) - 1; i >= 0 && total > maxSize; i-- {
+		if !selected[i] {
+			selected[i] = true
+			release(sessions[i])
+		}
+	}
+}
+
+// PruneBackupSessions removes the sessions selected by opts and returns them
+func (e *Engine) PruneBackupSessions(opts PruneOptions) ([]BackupSessionInfo, error) {
+	sessions, err := e.SelectSessionsToPrune(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []BackupSessionInfo
+	for _, session := range sessions {
+		if err := e.removeSessionDir(session.Dir); err != nil {
+			log.Warn().Str("session", session.Dir).Err(err).Msg("Failed to remove backup session")
+			continue
+		}
+		removed = append(removed, session)
+	}
+
+	if len(removed) > 0 {
+		e.collectStoreGarbage()
+	}
+	return removed, nil
+}
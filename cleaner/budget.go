@@ -0,0 +1,116 @@
+package cleaner
+
+import (
+	"errors"
+	"fmt"
+
+	"Cursor_Windsurf_Reset/config"
+)
+
+// ErrBackupBudgetExceeded is returned when a phase's backups would not fit into the backup budget
+var ErrBackupBudgetExceeded = errors.New("backup budget exceeded")
+
+// BackupBudget describes a phase whose backups would exceed the configured budget
+type BackupBudget struct {
+	AppName   string
+	Phase     string
+	Estimated int64 // 本阶段预计的备份大小
+	Used      int64 // 本次运行已经产生的备份大小
+	Limit     int64 // MaxBackupSizeMB 换算后的字节数，0 表示不限制
+	Free      int64 // 备份目录所在磁盘的可用空间，-1 表示未知
+}
+
+// exceedsLimit reports whether the budget's size limit is exceeded
+func (b BackupBudget) exceedsLimit() bool {
+	return b.Limit > 0 && b.Used+b.Estimated > b.Limit
+}
+
+// exceedsFreeSpace reports whether the backups would not fit on the backup volume
+func (b BackupBudget) exceedsFreeSpace() bool {
+	return b.Free >= 0 && b.Estimated > b.Free
+}
+
+// SetBudgetPrompt sets the callback asked for a decision when BudgetPolicy is "ask".
+// 回调返回 true 表示继续执行：缓存阶段跳过备份，其他阶段允许超出大小限制
+func (e *Engine) SetBudgetPrompt(prompt func(BackupBudget) bool) {
+	e.budgetPrompt = prompt
+}
+
+// estimateBackupSize returns the total size of the files and directories a phase is about to back up
+func (e *Engine) estimateBackupSize(paths []string) int64 {
+	var total int64
+	for _, path := range paths {
+		total += e.GetDirectorySize(path)
+	}
+	return total
+}
+
+// checkBackupBudget applies the configured BudgetPolicy before a phase backs up paths
+func (e *Engine) checkBackupBudget(phase string, paths []string) error {
+	if !e.config.BackupOptions.Enabled || e.session == nil || len(paths) == 0 {
+		return nil
+	}
+
+	budget := BackupBudget{
+		AppName:   e.session.manifest.AppName,
+		Phase:     phase,
+		Estimated: e.estimateBackupSize(paths),
+		Limit:     int64(e.config.BackupOptions.MaxBackupSizeMB) * 1024 * 1024,
+		Free:      -1,
+	}
+	for _, item := range e.session.manifest.Items {
+		budget.Used += item.Size
+	}
+	if free, err := freeDiskSpace(e.backupBaseDir); err == nil {
+		budget.Free = int64(free)
+	} else {
+		log.Debug().Str("path", e.backupBaseDir).Err(err).Msg("Failed to get free disk space")
+	}
+
+	if !budget.exceedsLimit() && !budget.exceedsFreeSpace() {
+		return nil
+	}
+
+	log.Warn().
+		Str("phase", phase).
+		Str("estimated", e.FormatSize(budget.Estimated)).
+		Str("used", e.FormatSize(budget.Used)).
+		Str("limit", e.FormatSize(budget.Limit)).
+		Int64("free_bytes", budget.Free).
+		Msg("Backups would exceed the backup budget")
+
+	policy := e.config.BackupOptions.BudgetPolicy
+	if policy == "" {
+		policy = config.BudgetPolicySkipCache
+	}
+
+	if e.dryRun {
+		// dry-run 不做备份，只报告实际运行时会按哪种策略处理
+		log.Info().Str("phase", phase).Str("policy", policy).Msg("Would apply backup budget policy")
+		return nil
+	}
+
+	switch policy {
+	case config.BudgetPolicySkipCache:
+		if phase == PhaseCache {
+			e.skipCacheBackups(budget)
+			return nil
+		}
+	case config.BudgetPolicyAsk:
+		// 磁盘空间不足时非缓存阶段无论如何都无法完成备份，不再询问
+		if e.budgetPrompt != nil && (phase == PhaseCache || !budget.exceedsFreeSpace()) && e.budgetPrompt(budget) {
+			if phase == PhaseCache {
+				e.skipCacheBackups(budget)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %s phase needs %s of backups (already used %s, limit %s)",
+		ErrBackupBudgetExceeded, phase, e.FormatSize(budget.Estimated), e.FormatSize(budget.Used), e.FormatSize(budget.Limit))
+}
+
+func (e *Engine) skipCacheBackups(budget BackupBudget) {
+	log.Warn().Str("estimated", e.FormatSize(budget.Estimated)).Msg("Cache directories will be cleared without backups")
+	e.session.skipCacheBackups = true
+}
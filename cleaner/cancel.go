@@ -0,0 +1,27 @@
+package cleaner
+
+import (
+	"fmt"
+)
+
+// CancelledError is returned by ApplyPlan when its context is cancelled.
+// 取消时正在处理的文件要么已完整处理，要么保持原样；Done 只列出已完整处理的文件和目录
+type CancelledError struct {
+	AppName string
+	Phase   string   // phase that was running when the reset stopped
+	Done    []string // files and directories completely processed before the reset stopped
+	Err     error    // the context's error
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("reset of %s cancelled during the %s phase after %d completed item(s): %v", e.AppName, e.Phase, len(e.Done), e.Err)
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+// markDone records that path was completely processed by the running reset
+func (e *Engine) markDone(path string) {
+	e.done = append(e.done, path)
+}
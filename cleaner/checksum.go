@@ -0,0 +1,263 @@
+package cleaner
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"Cursor_Windsurf_Reset/config"
+)
+
+// ErrBackupVerification is returned when a backup does not match its source
+var ErrBackupVerification = errors.New("backup verification failed")
+
+// treeEntry 目录树中单个文件的相对路径及其内容哈希
+type treeEntry struct {
+	relPath string
+	sum     string
+	link    bool // 符号链接，sum 为 symlinkSHA256
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of a file's contents
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return readerSHA256(file)
+}
+
+func readerSHA256(reader io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// symlinkSHA256 符号链接不跟随，只对链接目标路径做哈希
+func symlinkSHA256(target string) string {
+	sum := sha256.Sum256([]byte("symlink\x00" + target))
+	return hex.EncodeToString(sum[:])
+}
+
+// hashTreeEntries 按相对路径排序后对所有条目再做一次SHA-256，使结果与遍历顺序无关
+func hashTreeEntries(entries []treeEntry) string {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].relPath < entries[j].relPath
+	})
+
+	hash := sha256.New()
+	for _, entry := range entries {
+		fmt.Fprintf(hash, "%s\x00%s\n", entry.relPath, entry.sum)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// treeSHA256 returns a checksum over the relative paths and contents of every file and symlink below root
+func treeSHA256(root string) (string, error) {
+	entries, err := treeEntries(root)
+	if err != nil {
+		return "", err
+	}
+	return hashTreeEntries(entries), nil
+}
+
+// treeEntries returns the relative path and content hash of every file and symlink below root
+func treeEntries(root string) ([]treeEntry, error) {
+	var entries []treeEntry
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || (!info.Mode().IsRegular() && !isSymlink(info.Mode())) {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		var sum string
+		if isSymlink(info.Mode()) {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			sum = symlinkSHA256(target)
+		} else if sum, err = fileSHA256(path); err != nil {
+			return err
+		}
+
+		entries = append(entries, treeEntry{filepath.ToSlash(relPath), sum, isSymlink(info.Mode())})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// pathSHA256 returns fileSHA256 for files and treeSHA256 for directories
+func pathSHA256(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return treeSHA256(path)
+	}
+	return fileSHA256(path)
+}
+
+// zipSHA256 computes the checksum of a zip backup the same way pathSHA256 does for its source
+func zipSHA256(zipPath string, isDir bool) (string, error) {
+	entries, err := zipEntries(zipPath)
+	if err != nil {
+		return "", err
+	}
+	return archiveSHA256(zipPath, entries, isDir)
+}
+
+// zipEntries returns the path and content hash of every file and symlink in a zip backup
+func zipEntries(zipPath string) ([]treeEntry, error) {
+	reader, err := zip.OpenReader(zipPath)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var entries []treeEntry
+	for _, file := range reader.File {
+		if file.FileInfo().IsDir() {
+			continue
+		}
+
+		src, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		var sum string
+		link := zipHasMetadata(file) && isSymlink(file.Mode())
+		if link {
+			var target []byte
+			if target, err = io.ReadAll(src); err == nil {
+				sum = symlinkSHA256(string(target))
+			}
+		} else {
+			sum, err = readerSHA256(src)
+		}
+		src.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, treeEntry{filepath.ToSlash(file.Name), sum, link})
+	}
+
+	return entries, nil
+}
+
+// archiveSHA256 returns the checksum of a file backup archive, its only entry, or the tree checksum of a directory backup archive
+func archiveSHA256(archivePath string, entries []treeEntry, isDir bool) (string, error) {
+	if isDir {
+		return hashTreeEntries(entries), nil
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("file backup archive is empty: %s", archivePath)
+	}
+	return entries[0].sum, nil
+}
+
+// tarSHA256 computes the checksum of a tar backup the same way pathSHA256 does for its source
+func tarSHA256(archivePath string, isDir bool, format string) (string, error) {
+	entries, err := tarEntries(archivePath, format)
+	if err != nil {
+		return "", err
+	}
+	return archiveSHA256(archivePath, entries, isDir)
+}
+
+// tarEntries returns the path and content hash of every file and symlink in a tar backup
+func tarEntries(archivePath, format string) ([]treeEntry, error) {
+	var entries []treeEntry
+	err := readTarBackup(archivePath, format, func(header *tar.Header, reader *tar.Reader) error {
+		var sum string
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			sum = symlinkSHA256(header.Linkname)
+		case tar.TypeReg:
+			var err error
+			if sum, err = readerSHA256(reader); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		entries = append(entries, treeEntry{header.Name, sum, header.Typeflag == tar.TypeSymlink})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// verifyStoreBackup re-reads the objects of a store backup and compares them with the source checksum
+func verifyStoreBackup(store *backupStore, files []StoreFile, sourceSum string, isDir bool) error {
+	if sourceSum == "" {
+		return fmt.Errorf("%w: source checksum unavailable for store backup", ErrBackupVerification)
+	}
+
+	backupSum, err := store.storeSHA256(files, isDir)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBackupVerification, err)
+	}
+	if backupSum != sourceSum {
+		return fmt.Errorf("%w: checksum mismatch for store backup", ErrBackupVerification)
+	}
+
+	return nil
+}
+
+// verifyBackup re-reads a freshly written backup and compares it with the source checksum
+func verifyBackup(backupPath, sourceSum string, isDir bool, format string) error {
+	if sourceSum == "" {
+		return fmt.Errorf("%w: source checksum unavailable for %s", ErrBackupVerification, backupPath)
+	}
+
+	var backupSum string
+	var err error
+	switch format {
+	case config.BackupFormatZip:
+		backupSum, err = zipSHA256(backupPath, isDir)
+	case config.BackupFormatTarGz, config.BackupFormatTarZst:
+		backupSum, err = tarSHA256(backupPath, isDir, format)
+	default:
+		backupSum, err = pathSHA256(backupPath)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrBackupVerification, backupPath, err)
+	}
+
+	if backupSum != sourceSum {
+		return fmt.Errorf("%w: checksum mismatch for %s", ErrBackupVerification, backupPath)
+	}
+
+	return nil
+}
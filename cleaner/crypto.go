@@ -0,0 +1,250 @@
+package cleaner
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// encryptedExtension 加密后的备份在原文件名后追加该扩展名
+	encryptedExtension = ".enc"
+	// encryptionChunkSize 备份按块加密，解密时不需要把整个文件读入内存
+	encryptionChunkSize = 64 * 1024
+
+	encryptionCipher = "aes-256-gcm"
+	encryptionKDF    = "argon2id"
+	// encryptionNoncePrefixSize 每个文件使用随机前缀，nonce = 前缀 + 4 字节块序号 + 1 字节末块标记
+	encryptionNoncePrefixSize = 7
+)
+
+// encryptionMagic 加密备份文件的开头，用于识别文件格式
+var encryptionMagic = []byte("CWRENC1\n")
+
+// EncryptionInfo records how the backups of a session were encrypted. 只保存派生密钥所需的参数，不保存密钥本身
+type EncryptionInfo struct {
+	Cipher   string `json:"cipher"`
+	KDF      string `json:"kdf"`
+	Salt     string `json:"salt"`
+	Time     uint32 `json:"time"`
+	Memory   uint32 `json:"memory"` // KiB
+	Threads  uint8  `json:"threads"`
+	KeyCheck string `json:"key_check"` // 用于在解密前判断密码是否正确
+}
+
+// newEncryptionInfo derives a key for a new session from passphrase with a random salt
+func newEncryptionInfo(passphrase string) (*EncryptionInfo, []byte, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, err
+	}
+
+	info := &EncryptionInfo{
+		Cipher:  encryptionCipher,
+		KDF:     encryptionKDF,
+		Salt:    hex.EncodeToString(salt),
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+	}
+
+	key := argon2.IDKey([]byte(passphrase), salt, info.Time, info.Memory, info.Threads, 32)
+	info.KeyCheck = keyCheck(key)
+	return info, key, nil
+}
+
+// deriveKey derives the session key from passphrase and reports whether the passphrase is correct
+func (info *EncryptionInfo) deriveKey(passphrase string) ([]byte, bool, error) {
+	if info.Cipher != encryptionCipher || info.KDF != encryptionKDF {
+		return nil, false, fmt.Errorf("unsupported backup encryption: %s/%s", info.Cipher, info.KDF)
+	}
+
+	salt, err := hex.DecodeString(info.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, false, fmt.Errorf("invalid backup encryption salt")
+	}
+
+	key := argon2.IDKey([]byte(passphrase), salt, info.Time, info.Memory, info.Threads, 32)
+	if !hmac.Equal([]byte(keyCheck(key)), []byte(info.KeyCheck)) {
+		return nil, false, nil
+	}
+	return key, true, nil
+}
+
+func keyCheck(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("Cursor_Windsurf_Reset backup key check"))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func encryptionNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptionNoncePrefixSize:], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
+
+func newBackupAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptFile encrypts src into dst with AES-256-GCM in 64 KiB chunks.
+// 每块的 nonce 包含块序号和末块标记，块被重排、删除或文件被截断时解密都会失败
+func encryptFile(key []byte, src, dst string) error {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
+	if err != nil {
+		return err
+	}
+
+	err = encryptStream(aead, bufio.NewReaderSize(in, encryptionChunkSize), out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+func encryptStream(aead cipher.AEAD, r *bufio.Reader, w io.Writer) error {
+	prefix := make([]byte, encryptionNoncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return err
+	}
+	if _, err := w.Write(append(append([]byte{}, encryptionMagic...), prefix...)); err != nil {
+		return err
+	}
+
+	buf := make([]byte, encryptionChunkSize)
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		last := err != nil
+		if !last {
+			// 恰好读满一块时需要向后看一个字节才能知道这是否是最后一块
+			if _, peekErr := r.Peek(1); peekErr == io.EOF {
+				last = true
+			}
+		}
+
+		sealed := aead.Seal(nil, encryptionNonce(prefix, counter, last), buf[:n], encryptionMagic)
+		if _, err := w.Write(sealed); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if counter == ^uint32(0) {
+			return errors.New("backup too large to encrypt")
+		}
+	}
+}
+
+// decryptStream authenticates and decrypts an encrypted backup from r into w.
+// 写入 w 的每一块都已通过认证，但只有返回 nil 时整个文件才是完整的
+func decryptStream(key []byte, r io.Reader, w io.Writer) error {
+	aead, err := newBackupAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, len(encryptionMagic)+encryptionNoncePrefixSize)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(encryptionMagic)]) != string(encryptionMagic) {
+		return errors.New("not an encrypted backup")
+	}
+	prefix := header[len(encryptionMagic):]
+
+	br := bufio.NewReaderSize(r, encryptionChunkSize+aead.Overhead())
+	buf := make([]byte, encryptionChunkSize+aead.Overhead())
+	for counter := uint32(0); ; counter++ {
+		n, err := io.ReadFull(br, buf)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return errors.New("encrypted backup is truncated")
+		}
+
+		last := err != nil
+		if !last {
+			if _, peekErr := br.Peek(1); peekErr == io.EOF {
+				last = true
+			}
+		}
+
+		plain, err := aead.Open(buf[:0], encryptionNonce(prefix, counter, last), buf[:n], encryptionMagic)
+		if err != nil {
+			return errors.New("encrypted backup failed authentication")
+		}
+		if _, err := w.Write(plain); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+	}
+}
+
+// decryptFile decrypts src into dst, removing dst if the backup fails authentication
+func decryptFile(key []byte, src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
+	if err != nil {
+		return err
+	}
+
+	err = decryptStream(key, in, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	return nil
+}
+
+// verifyEncryptedBackup 完整解密一遍但不保存结果，确认加密文件可以被认证
+func verifyEncryptedBackup(key []byte, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return decryptStream(key, file, io.Discard)
+}
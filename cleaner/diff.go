@@ -0,0 +1,402 @@
+package cleaner
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of differences between a backup and the live profile
+const (
+	DiffAdded   = "added"
+	DiffRemoved = "removed"
+	DiffChanged = "changed"
+)
+
+// Ways a backed-up item is compared to its source path
+const (
+	DiffTypeSQLite    = "sqlite"
+	DiffTypeJSON      = "json"
+	DiffTypeDirectory = "directory"
+	DiffTypeFile      = "file"
+)
+
+const (
+	redactedValue = "[REDACTED]"
+	// maxDiffValueLength ItemTable 中的值可能是很长的 JSON，输出时截断
+	maxDiffValueLength = 120
+)
+
+// sensitiveKeyFragments 键名或 JSON 路径包含这些片段时只报告值是否变化，不输出值本身
+var sensitiveKeyFragments = []string{"token", "secret", "password", "passwd", "apikey", "api_key", "credential", "cookie", "auth"}
+
+// SessionDiff describes what changed between a backup session and the live files it was taken from
+type SessionDiff struct {
+	SessionID string     `json:"session_id"`
+	AppName   string     `json:"app_name"`
+	Items     []ItemDiff `json:"items"`
+}
+
+// ItemDiff compares one backed-up file or directory to its source path
+type ItemDiff struct {
+	SourcePath string      `json:"source_path"`
+	Phase      string      `json:"phase"`
+	Type       string      `json:"type"`
+	Missing    bool        `json:"missing"` // 源路径已不存在
+	Entries    []DiffEntry `json:"entries"`
+	Err        error       `json:"-"` // 备份无法读取或比较失败
+}
+
+// MarshalJSON adds Err as "error"
+func (d ItemDiff) MarshalJSON() ([]byte, error) {
+	type itemDiff ItemDiff
+	return json.Marshal(struct {
+		itemDiff
+		Error string `json:"error,omitempty"`
+	}{itemDiff(d), errorString(d.Err)})
+}
+
+// DiffEntry is a single ItemTable key, JSON path or file that differs. 敏感值已被替换为 [REDACTED]
+type DiffEntry struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// DiffSession compares every item of a backup session given as "<id>" or "<app>/<id>" to the live files
+func (e *Engine) DiffSession(sessionID string) (*SessionDiff, error) {
+	manifest, err := e.loadManifest(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := e.sessionKey(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	// 备份先解压到备份目录下的临时目录，与备份本身一样只允许当前用户访问
+	tmpDir, err := os.MkdirTemp(e.backupBaseDir, ".diff-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	diff := &SessionDiff{SessionID: manifest.SessionID, AppName: manifest.AppName}
+	for i, item := range restorableItems(manifest) {
+		backupCopy := filepath.Join(tmpDir, strconv.Itoa(i), filepath.Base(item.SourcePath))
+		diff.Items = append(diff.Items, e.diffItem(manifest.dir, item, key, backupCopy))
+	}
+
+	return diff, nil
+}
+
+func (e *Engine) diffItem(sessionDir string, item ManifestItem, key []byte, backupCopy string) ItemDiff {
+	result := ItemDiff{SourcePath: item.SourcePath, Phase: item.Phase, Type: DiffTypeFile}
+	if item.IsDir {
+		result.Type = DiffTypeDirectory
+	}
+
+	if err := e.extractBackup(sessionDir, item, key, backupCopy); err != nil {
+		result.Err = err
+		return result
+	}
+
+	if _, err := os.Stat(item.SourcePath); os.IsNotExist(err) {
+		result.Missing = true
+		return result
+	}
+
+	if item.IsDir {
+		result.Entries, result.Err = diffDirectories(backupCopy, item.SourcePath)
+		return result
+	}
+
+	if isSQLiteDatabase(backupCopy) && isSQLiteDatabase(item.SourcePath) {
+		entries, err := e.diffSQLite(backupCopy, item.SourcePath)
+		if err == nil {
+			result.Type = DiffTypeSQLite
+			result.Entries = entries
+			return result
+		}
+		// 没有 ItemTable 的数据库只比较文件内容
+		log.Debug().Str("path", item.SourcePath).Err(err).Msg("Failed to compare ItemTable, comparing file contents")
+	} else if entries, ok := e.diffJSONFiles(backupCopy, item.SourcePath); ok {
+		result.Type = DiffTypeJSON
+		result.Entries = entries
+		return result
+	}
+
+	result.Entries, result.Err = diffFiles(backupCopy, item.SourcePath)
+	return result
+}
+
+// extractBackup writes the contents of a backup to target instead of its source path
+func (e *Engine) extractBackup(sessionDir string, item ManifestItem, key []byte, target string) error {
+	format := item.BackupFormat()
+	backupPath := filepath.Join(sessionDir, item.BackupPath)
+
+	if item.Encrypted {
+		decrypted, err := decryptBackup(sessionDir, backupPath, key)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(decrypted)
+		backupPath = decrypted
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), backupDirMode); err != nil {
+		return err
+	}
+	if item.IsDir {
+		if err := os.MkdirAll(target, backupDirMode); err != nil {
+			return err
+		}
+	}
+
+	item.SourcePath = target
+	return e.restoreItemContents(backupPath, item, format)
+}
+
+// diffDirectories compares the file lists and contents of two directory trees
+func diffDirectories(before, after string) ([]DiffEntry, error) {
+	beforeEntries, err := treeEntries(before)
+	if err != nil {
+		return nil, err
+	}
+	afterEntries, err := treeEntries(after)
+	if err != nil {
+		return nil, err
+	}
+
+	toMap := func(entries []treeEntry) map[string]string {
+		sums := make(map[string]string, len(entries))
+		for _, entry := range entries {
+			sums[entry.relPath] = entry.sum
+		}
+		return sums
+	}
+
+	return diffMaps(toMap(beforeEntries), toMap(afterEntries), func(path, sum string) string { return "" }), nil
+}
+
+// diffFiles reports a single changed entry when two files differ
+func diffFiles(before, after string) ([]DiffEntry, error) {
+	beforeSum, err := fileSHA256(before)
+	if err != nil {
+		return nil, err
+	}
+	afterSum, err := fileSHA256(after)
+	if err != nil {
+		return nil, err
+	}
+
+	if beforeSum == afterSum {
+		return nil, nil
+	}
+	return []DiffEntry{{Kind: DiffChanged, Key: filepath.Base(after), Before: "sha256:" + beforeSum[:12], After: "sha256:" + afterSum[:12]}}, nil
+}
+
+// diffSQLite compares the ItemTable rows of two VS Code style state databases key by key
+func (e *Engine) diffSQLite(before, after string) ([]DiffEntry, error) {
+	beforeRows, err := readItemTable(before)
+	if err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	afterRows, err := readItemTable(after)
+	if err != nil {
+		return nil, fmt.Errorf("current: %w", err)
+	}
+
+	return diffMaps(beforeRows, afterRows, e.formatDiffValue), nil
+}
+
+// readItemTable reads the ItemTable of a state database without writing to it.
+// 应用可能正在运行，只读打开不会创建 -wal/-shm 文件，也不会触发检查点
+func readItemTable(dbPath string) (map[string]string, error) {
+	db, err := sql.Open("sqlite", readOnlyDSN(dbPath))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT key, value FROM ItemTable")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make(map[string]string)
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+		items[key] = string(value)
+	}
+	return items, rows.Err()
+}
+
+// readOnlyDSN returns a connection string that opens dbPath read-only; 驱动只在 file: URI 中识别 mode 参数。
+// 没有 -wal 文件时说明没有连接在使用 WAL，加上 immutable=1，否则只读打开 WAL 数据库仍会创建 -wal/-shm
+func readOnlyDSN(dbPath string) string {
+	query := "mode=ro&_pragma=busy_timeout(5000)"
+	if _, err := os.Stat(dbPath + "-wal"); os.IsNotExist(err) {
+		query += "&immutable=1"
+	}
+
+	if absPath, err := filepath.Abs(dbPath); err == nil {
+		dbPath = absPath
+	}
+	path := filepath.ToSlash(dbPath)
+	if !strings.HasPrefix(path, "/") {
+		// Windows 盘符路径写成 file:///C:/...
+		path = "/" + path
+	}
+	dsn := url.URL{Scheme: "file", Path: path, RawQuery: query}
+	return dsn.String()
+}
+
+// diffJSONFiles compares two JSON files path by path; ok 为 false 表示其中一个文件不是 JSON
+func (e *Engine) diffJSONFiles(before, after string) (entries []DiffEntry, ok bool) {
+	beforeValues, err := readFlatJSON(before)
+	if err != nil {
+		return nil, false
+	}
+	afterValues, err := readFlatJSON(after)
+	if err != nil {
+		return nil, false
+	}
+
+	return diffMaps(beforeValues, afterValues, e.formatDiffValue), true
+}
+
+func readFlatJSON(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string)
+	flattenJSON("", value, values)
+	return values, nil
+}
+
+// flattenJSON 把嵌套的 JSON 展开为 路径 -> 值，对象键用 . 连接，数组元素用 [i]
+func flattenJSON(path string, value interface{}, out map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if len(v) > 0 {
+			for key, child := range v {
+				childPath := key
+				if path != "" {
+					childPath = path + "." + key
+				}
+				flattenJSON(childPath, child, out)
+			}
+			return
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			for i, child := range v {
+				flattenJSON(fmt.Sprintf("%s[%d]", path, i), child, out)
+			}
+			return
+		}
+	}
+
+	data, _ := json.Marshal(value)
+	out[path] = string(data)
+}
+
+// diffMaps compares two key/value maps and formats the differing values with format, sorted by key
+func diffMaps(before, after map[string]string, format func(key, value string) string) []DiffEntry {
+	var entries []DiffEntry
+
+	for key, beforeValue := range before {
+		afterValue, exists := after[key]
+		switch {
+		case !exists:
+			entries = append(entries, DiffEntry{Kind: DiffRemoved, Key: key, Before: format(key, beforeValue)})
+		case afterValue != beforeValue:
+			entries = append(entries, DiffEntry{Kind: DiffChanged, Key: key, Before: format(key, beforeValue), After: format(key, afterValue)})
+		}
+	}
+	for key, afterValue := range after {
+		if _, exists := before[key]; !exists {
+			entries = append(entries, DiffEntry{Kind: DiffAdded, Key: key, After: format(key, afterValue)})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// isSensitiveKey reports whether the value of key must not be shown
+func (e *Engine) isSensitiveKey(key string) bool {
+	lower := strings.ToLower(key)
+	for _, fragment := range sensitiveKeyFragments {
+		if strings.Contains(lower, fragment) {
+			return true
+		}
+	}
+	for _, sessionKey := range e.config.CleaningOptions.SessionKeys {
+		if strings.Contains(lower, strings.ToLower(sessionKey)) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatDiffValue redacts sensitive values and shortens long ones.
+// ItemTable 的值常常是嵌套 JSON，其中的敏感字段也会被替换
+func (e *Engine) formatDiffValue(key, value string) string {
+	if e.isSensitiveKey(key) {
+		return redactedValue
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err == nil {
+		if data, err := json.Marshal(e.redactJSON(parsed)); err == nil {
+			value = string(data)
+		}
+	}
+
+	if runes := []rune(value); len(runes) > maxDiffValueLength {
+		value = string(runes[:maxDiffValueLength]) + "..."
+	}
+	return value
+}
+
+func (e *Engine) redactJSON(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			if e.isSensitiveKey(key) {
+				v[key] = redactedValue
+			} else {
+				v[key] = e.redactJSON(child)
+			}
+		}
+	case []interface{}:
+		for i, child := range v {
+			v[i] = e.redactJSON(child)
+		}
+	}
+	return value
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+// +build !windows
+
+package cleaner
+
+import (
+	"syscall"
+)
+
+// freeDiskSpace returns the number of bytes available to the current user on the volume holding path
+func freeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
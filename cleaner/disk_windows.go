@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package cleaner
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// freeDiskSpace returns the number of bytes available to the current user on the volume holding path
+func freeDiskSpace(path string) (uint64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+
+	getDiskFreeSpaceEx := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+
+	var freeBytes uint64
+	ret, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&freeBytes)), 0, 0)
+	if ret == 0 {
+		return 0, err
+	}
+
+	return freeBytes, nil
+}
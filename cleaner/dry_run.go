@@ -0,0 +1,40 @@
+package cleaner
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// withDryRunCopy calls fn with path, or during a dry run with a scratch copy of path, and returns its error.
+// 各阶段在 dry-run 时对副本执行完全相同的修改，因此报告的键数和记录数与实际运行一致，而原文件不会被写入
+func (e *Engine) withDryRunCopy(path string, fn func(target string) error) error {
+	if !e.dryRun {
+		return fn(path)
+	}
+
+	tmpDir, err := os.MkdirTemp("", "cwr-dry-run-")
+	if err != nil {
+		log.Warn().Str("path", path).Err(err).Msg("Failed to create dry-run workspace")
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	target := filepath.Join(tmpDir, filepath.Base(path))
+	if err := copyForDryRun(path, target); err != nil {
+		log.Warn().Str("path", path).Err(err).Msg("Failed to copy file for dry run")
+		return err
+	}
+
+	return fn(target)
+}
+
+// copyForDryRun 通过 VACUUM INTO 复制 SQLite 数据库，使副本包含尚在 -wal 中的内容；失败时退回普通复制
+func copyForDryRun(path, target string) error {
+	if isSQLiteDatabase(path) {
+		if err := vacuumInto(path, target); err == nil {
+			return nil
+		}
+		os.Remove(target)
+	}
+	return copyFile(path, target)
+}
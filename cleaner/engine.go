@@ -4,7 +4,6 @@ import (
 	"archive/zip"
 	"context"
 	"database/sql"
-	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -16,7 +15,6 @@ import (
 
 	"Cursor_Windsurf_Reset/config"
 	appi18n "Cursor_Windsurf_Reset/i18n"
-	"github.com/google/uuid"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/rs/zerolog"
 	_ "modernc.org/sqlite"
@@ -36,6 +34,18 @@ type Engine struct {
 	verbose       bool
 	progressChan  chan ProgressUpdate
 	localizer     *appi18n.LocalizerWrapper
+	session       *backupSession
+	lastSessionID string
+	budgetPrompt  func(BackupBudget) bool
+	passphrase    string
+	// phases 由 SetPhases 设置，覆盖配置中启用的阶段
+	phases []string
+	// done 本次 ApplyPlan 中已完整处理的文件和目录，取消时报告
+	done []string
+	// touched 本次开始修改的路径，事务模式据此撤销
+	touched []string
+	// current 正在执行的阶段的结果
+	current *PhaseResult
 }
 
 type ProgressUpdate struct {
@@ -46,11 +56,18 @@ type ProgressUpdate struct {
 	Phase    string  `json:"phase,omitempty"`
 }
 
+// Cleaning phases, used in progress updates and backup manifests
+const (
+	PhaseTelemetry = "telemetry"
+	PhaseDatabase  = "database"
+	PhaseCache     = "cache"
+)
+
 type CacheStats struct {
-	DirCount    int
-	TotalSize   int64
-	TotalFiles  int
-	CleanedDirs int
+	DirCount    int   `json:"dir_count"`
+	TotalSize   int64 `json:"total_size"`
+	TotalFiles  int   `json:"total_files"`
+	CleanedDirs int   `json:"cleaned_dirs"`
 }
 
 func NewEngine(cfg *config.Config, dryRun, verbose bool, localizer *appi18n.LocalizerWrapper) *Engine {
@@ -73,17 +90,59 @@ func (e *Engine) GetProgressChannel() <-chan ProgressUpdate {
 	return e.progressChan
 }
 
+// setupBackupDirectory resolves BackupOptions.Directory and creates it.
+// 失败时只记录日志，由 CheckBackupDirectory 在开始重置前报告错误
 func (e *Engine) setupBackupDirectory() {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to get home directory")
-		homeDir = "."
+	dir := e.config.BackupOptions.Directory
+	if dir == "" {
+		dir = config.DefaultBackupDirectory
+	}
+
+	e.backupBaseDir = e.expandPathTemplate(dir)
+	if absDir, err := filepath.Abs(e.backupBaseDir); err == nil {
+		e.backupBaseDir = absDir
+	}
+
+	_, statErr := os.Stat(e.backupBaseDir)
+	existed := statErr == nil
+	if err := os.MkdirAll(e.backupBaseDir, backupDirMode); err != nil {
+		log.Error().Err(err).Str("dir", e.backupBaseDir).Msg("Failed to create backup directory")
+		return
+	}
+	// 旧版本以 0755 创建了默认备份目录，收紧其权限；用户指定的已有目录可能是共享目录，保持原样
+	if existed && e.isDefaultBackupDirectory() {
+		if err := os.Chmod(e.backupBaseDir, backupDirMode); err != nil {
+			log.Warn().Err(err).Msg("Failed to restrict backup directory permissions")
+		}
+	}
+}
+
+// isDefaultBackupDirectory reports whether backups are kept in DefaultBackupDirectory, which the tool itself creates
+func (e *Engine) isDefaultBackupDirectory() bool {
+	defaultDir, err := filepath.Abs(e.expandPathTemplate(config.DefaultBackupDirectory))
+	return err == nil && defaultDir == e.backupBaseDir
+}
+
+// CheckBackupDirectory reports an error if backups are enabled but the backup directory cannot be created or written to
+func (e *Engine) CheckBackupDirectory() error {
+	if !e.config.BackupOptions.Enabled {
+		return nil
+	}
+
+	err := os.MkdirAll(e.backupBaseDir, backupDirMode)
+	if err == nil {
+		// 目录存在不代表可写（只读卷、权限不足），实际写入一个临时文件确认
+		var probe *os.File
+		if probe, err = os.CreateTemp(e.backupBaseDir, ".write-test-*"); err == nil {
+			probe.Close()
+			err = os.Remove(probe.Name())
+		}
 	}
 
-	e.backupBaseDir = filepath.Join(homeDir, "CursorWindsurf_Advanced_Backups")
-	if err := os.MkdirAll(e.backupBaseDir, 0755); err != nil {
-		log.Error().Err(err).Msg("Failed to create backup directory")
+	if err != nil {
+		return fmt.Errorf(e.localizeMessage("BackupDirectoryUnavailable", map[string]interface{}{"Dir": e.backupBaseDir, "Error": err}))
 	}
+	return nil
 }
 
 // discoverAppDataPaths discovers application data paths
@@ -175,449 +234,263 @@ func (e *Engine) IsAppRunning(appName string) bool {
 	return false
 }
 
+// CreateBackup backs up sourcePath into the backup directory under backupName
 func (e *Engine) CreateBackup(sourcePath, backupName string) (string, error) {
+	return e.createBackup("", sourcePath, backupName)
+}
+
+// createBackup 创建备份并将其记录到当前会话的清单中，phase 标明是哪个阶段产生的备份
+func (e *Engine) createBackup(phase, sourcePath, backupName string) (string, error) {
 	if !e.config.BackupOptions.Enabled {
 		return "", nil
 	}
 
-	if _, err := os.Stat(sourcePath); os.IsNotExist(err) {
-		return "", fmt.Errorf(e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "SourcePathNotExist", TemplateData: map[string]interface{}{"Path": sourcePath}}))
+	if phase == PhaseCache && e.session != nil && e.session.skipCacheBackups {
+		log.Debug().Str("path", sourcePath).Msg("Skipping cache backup, backup budget exceeded")
+		return "", nil
 	}
 
-	timestamp := time.Now().Format("20060102_150405")
-	var backupPath string
-
-	if e.config.BackupOptions.Compression {
-		backupPath = filepath.Join(e.backupBaseDir, fmt.Sprintf("%s_%s.zip", backupName, timestamp))
-		return e.createCompressedBackup(sourcePath, backupPath)
-	} else {
-		backupPath = filepath.Join(e.backupBaseDir, fmt.Sprintf("%s_%s", backupName, timestamp))
-		return e.createDirectoryBackup(sourcePath, backupPath)
+	sourceInfo, err := os.Stat(sourcePath)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf(e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "SourcePathNotExist", TemplateData: map[string]interface{}{"Path": sourcePath}}))
+	} else if err != nil {
+		return "", err
 	}
-}
 
-func (e *Engine) createCompressedBackup(sourcePath, backupPath string) (string, error) {
-	zipFile, err := os.Create(backupPath)
-	if err != nil {
-		return "", err
+	if e.dryRun {
+		log.Info().Str("path", sourcePath).Str("phase", phase).Msg("Would back up")
+		return "", nil
 	}
-	defer zipFile.Close()
 
-	zipWriter := zip.NewWriter(zipFile)
-	defer zipWriter.Close()
+	// 在会话中时备份写入会话目录，会话目录名已包含时间戳
+	backupBase := filepath.Join(e.backupBaseDir, fmt.Sprintf("%s_%s", backupName, time.Now().Format(backupTimestampFormat)))
+	if e.session != nil {
+		if err := os.MkdirAll(e.session.dir, backupDirMode); err != nil {
+			return "", fmt.Errorf("failed to create backup session directory: %w", err)
+		}
+		backupBase = filepath.Join(e.session.dir, backupName)
+	}
 
-	fileInfo, err := os.Stat(sourcePath)
+	format, err := e.backupFormat()
 	if err != nil {
 		return "", err
 	}
+	if format == config.BackupFormatStore && e.session == nil {
+		// 没有会话清单引用的对象会被垃圾回收，会话之外的备份只能使用目录格式
+		format = config.BackupFormatDirectory
+	}
+	encrypted := e.session != nil && e.session.key != nil
+	if encrypted && (format == config.BackupFormatDirectory || format == config.BackupFormatStore) {
+		// 加密以单个文件为单位，目录格式和去重存储无法加密，改用 tar.gz 归档
+		format = config.BackupFormatTarGz
+	}
 
-	if fileInfo.IsDir() {
-		err = filepath.Walk(sourcePath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-
-			relPath, err := filepath.Rel(sourcePath, path)
-			if err != nil {
-				return err
-			}
-
-			if info.IsDir() {
-				return nil
-			}
-
-			file, err := os.Open(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-
-			zipEntry, err := zipWriter.Create(relPath)
-			if err != nil {
-				return err
-			}
-
-			_, err = io.Copy(zipEntry, file)
-			return err
-		})
-	} else {
-		file, err := os.Open(sourcePath)
-		if err != nil {
-			return "", err
-		}
-		defer file.Close()
-
-		zipEntry, err := zipWriter.Create(filepath.Base(sourcePath))
-		if err != nil {
-			return "", err
-		}
-
-		_, err = io.Copy(zipEntry, file)
+	// 直接复制正在使用的 SQLite 数据库可能得到不一致的文件并丢失 -wal 中的内容，
+	// 因此先生成一致的快照，备份和校验都针对快照进行
+	backupSource := sourcePath
+	if !sourceInfo.IsDir() && isSQLiteDatabase(sourcePath) {
+		snapshot, cleanup, err := e.snapshotSQLite(sourcePath, sourceInfo)
 		if err != nil {
-			return "", err
+			log.Warn().Str("path", sourcePath).Err(err).Msg("Failed to snapshot SQLite database, checkpointing and copying the file instead")
+			checkpointSQLite(sourcePath)
+		} else {
+			defer cleanup()
+			backupSource = snapshot
 		}
 	}
 
+	var backupPath string
+	var storeFiles []StoreFile
+	switch format {
+	case config.BackupFormatZip:
+		backupPath, err = e.createCompressedBackup(backupSource, uniqueBackupPath(backupBase, backupExtension(format)))
+	case config.BackupFormatTarGz, config.BackupFormatTarZst:
+		backupPath, err = e.createTarBackup(backupSource, uniqueBackupPath(backupBase, backupExtension(format)), format)
+	case config.BackupFormatStore:
+		storeFiles, err = e.createStoreBackup(backupSource)
+		backupPath = e.backupStore().dir
+	default:
+		backupPath, err = e.createDirectoryBackup(backupSource, uniqueBackupPath(backupBase, ""))
+	}
 	if err != nil {
 		return "", err
 	}
 
-	log.Info().Str("path", backupPath).Msg("Created compressed backup")
-	return backupPath, nil
-}
-
-func (e *Engine) createDirectoryBackup(sourcePath, backupPath string) (string, error) {
-	fileInfo, err := os.Stat(sourcePath)
+	sourceSum, err := pathSHA256(backupSource)
 	if err != nil {
-		return "", err
+		log.Warn().Str("path", sourcePath).Err(err).Msg("Failed to checksum backup source")
 	}
 
-	if fileInfo.IsDir() {
-		err = copyDirectory(sourcePath, backupPath)
-	} else {
-		err = copyFile(sourcePath, backupPath)
+	if e.config.SafetyOptions.VerifyBackups {
+		if format == config.BackupFormatStore {
+			// 校验失败的对象不会被任何清单引用，由垃圾回收清理
+			if err := verifyStoreBackup(e.backupStore(), storeFiles, sourceSum, sourceInfo.IsDir()); err != nil {
+				return "", err
+			}
+		} else if err := verifyBackup(backupPath, sourceSum, sourceInfo.IsDir(), format); err != nil {
+			os.RemoveAll(backupPath)
+			return "", err
+		}
+		log.Debug().Str("path", backupPath).Str("sha256", sourceSum).Msg("Backup verified")
 	}
 
-	if err != nil {
-		return "", err
+	if encrypted {
+		if backupPath, err = e.encryptBackup(backupPath); err != nil {
+			return "", err
+		}
 	}
 
-	log.Info().Str("path", backupPath).Msg("Created directory backup")
+	e.recordBackup(phase, sourcePath, backupPath, sourceInfo, format, sourceSum, storeFiles)
 	return backupPath, nil
 }
 
-func (e *Engine) CleanApplication(ctx context.Context, appName string) error {
-	e.sendProgress(ProgressUpdate{
-		Type:     "start",
-		Message:  e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "StartReset", TemplateData: map[string]interface{}{"AppName": appName}}),
-		AppName:  appName,
-		Progress: 0,
-	})
-
-	appPath, exists := e.appDataPaths[appName]
-	if !exists || appPath == "" {
-		return fmt.Errorf(e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "AppNotFound", TemplateData: map[string]interface{}{"AppName": appName}}))
-	}
-
-	// Safety checks
-	if e.config.SafetyOptions.CheckRunningProcesses {
-		if e.IsAppRunning(appName) {
-			return fmt.Errorf(e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "AppRunning", TemplateData: map[string]interface{}{"AppName": appName}}))
+// backupBeforeModify backs up path and reports whether the phase may go on to modify it.
+// 开启 VerifyBackups 时，只有备份成功且校验通过才允许修改原始文件
+func (e *Engine) backupBeforeModify(phase, path, backupName string) bool {
+	backupPath, err := e.createBackup(phase, path, backupName)
+	if err != nil {
+		if e.config.SafetyOptions.VerifyBackups {
+			log.Error().Str("path", path).Err(err).Msg("Backup failed or could not be verified, skipping")
+			return false
 		}
+		log.Warn().Str("path", path).Err(err).Msg("Failed to backup, continuing")
+		return true
 	}
 
-	// Clean old backups
-	e.cleanOldBackups()
-
-	// 初始缓存扫描
-	e.sendProgress(ProgressUpdate{
-		Type:     "discover",
-		Message:  e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "AnalyzeAppData"}),
-		AppName:  appName,
-		Progress: 10,
-	})
-
-	// 发现缓存信息
-	cacheInfo := e.DiscoverCacheInfo(appPath, appName)
-	var totalCacheSize int64
-	for _, size := range cacheInfo {
-		totalCacheSize += size
+	if backupPath != "" {
+		log.Info().Str("path", path).Str("backup", backupPath).Msg("Successfully created backup")
 	}
-
-	e.sendProgress(ProgressUpdate{
-		Type:     "discover",
-		Message:  e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "FoundCacheInfo", TemplateData: map[string]interface{}{"Count": len(cacheInfo), "Size": e.FormatSize(totalCacheSize)}}),
-		AppName:  appName,
-		Progress: 15,
-	})
-
-	// Phase 1: Telemetry ID modification
-	e.sendProgress(ProgressUpdate{
-		Type:     "phase",
-		Message:  e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ModifyingTelemetry"}),
-		AppName:  appName,
-		Phase:    "telemetry",
-		Progress: 20,
-	})
-
-	if err := e.modifyTelemetry(appPath, appName); err != nil {
-		log.Error().Err(err).Str("app", appName).Msg("Failed to modify telemetry")
-	}
-
-	// Phase 2: Database cleaning
-	e.sendProgress(ProgressUpdate{
-		Type:     "phase",
-		Message:  e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ResettingDatabase"}),
-		AppName:  appName,
-		Phase:    "database",
-		Progress: 50,
-	})
-
-	if err := e.cleanDatabases(appPath, appName); err != nil {
-		log.Error().Err(err).Str("app", appName).Msg("Failed to clean databases")
-	}
-
-	// Phase 3: Cache cleaning
-	e.sendProgress(ProgressUpdate{
-		Type:     "phase",
-		Message:  e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ResettingCache"}),
-		AppName:  appName,
-		Phase:    "cache",
-		Progress: 80,
-	})
-
-	if err := e.cleanCache(appPath, appName); err != nil {
-		log.Error().Err(err).Str("app", appName).Msg("Failed to clean cache")
-	}
-
-	e.sendProgress(ProgressUpdate{
-		Type:     "complete",
-		Message:  e.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ResetSuccess", TemplateData: map[string]interface{}{"AppName": appName}}),
-		AppName:  appName,
-		Progress: 100,
-	})
-
-	return nil
+	return true
 }
 
-// modifyTelemetry modifies telemetry IDs in database and JSON files
-func (e *Engine) modifyTelemetry(appPath, appName string) error {
-	telemetryKeys := e.config.CleaningOptions.TelemetryKeys
-	sessionKeys := e.config.CleaningOptions.SessionKeys
-	dbFiles := e.config.CleaningOptions.DatabaseFiles
-
-	// 使用增强的递归文件查找函数
-	log.Info().Str("app", appName).Str("path", appPath).Strs("target_files", dbFiles).Msg("Starting to find identifier files")
-	foundFiles := e.findFilesRecursiveAdvanced(appPath, dbFiles)
-
-	if len(foundFiles) == 0 {
-		// 如果没有找到配置的文件，尝试查找所有可能的数据库文件
-		log.Warn().Str("app", appName).Msg("No configured identifier files found, trying to find all possible database files")
-		foundFiles = e.findDatabaseFiles(appPath)
-	}
-
-	// 处理结果统计
-	var (
-		processedFiles  int
-		updatedKeys     int
-		deletedKeys     int
-		failedFiles     int
-		totalFoundFiles = len(foundFiles)
-	)
-
-	// 发送开始处理文件的消息
-	e.sendProgress(ProgressUpdate{
-		Type:     "telemetry",
-		Message:  e.localizeMessage("ProcessingStartFiles", map[string]interface{}{"Count": totalFoundFiles}),
-		Phase:    "telemetry",
-		Progress: 20,
-		AppName:  appName,
-	})
-
-	// 处理每个找到的文件
-	for fileIndex, filePath := range foundFiles {
-		// 发送当前处理的文件消息
-		progress := 22.0 + float64(fileIndex)*18.0/float64(totalFoundFiles+1)
-		e.sendProgress(ProgressUpdate{
-			Type: "telemetry",
-			Message: e.localizeMessage("ProcessingFile", map[string]interface{}{
-				"Current":  fileIndex + 1,
-				"Total":    totalFoundFiles,
-				"FileName": filepath.Base(filePath),
-			}),
-			Phase:    "telemetry",
-			Progress: progress,
-			AppName:  appName,
-		})
-
-		// 检查文件是否存在和可访问
-		if _, err := os.Stat(filePath); os.IsNotExist(err) {
-			log.Warn().Str("file", filePath).Msg("File does not exist, skipping")
-			failedFiles++
-			continue
-		}
-
-		// 创建备份
-		backupPath, err := e.CreateBackup(filePath, fmt.Sprintf("%s_telemetry_%s", appName, filepath.Base(filePath)))
-		if err != nil {
-			log.Warn().Str("file", filePath).Err(err).Msg("Failed to backup file, continuing")
-		} else {
-			log.Info().Str("file", filePath).Str("backup", backupPath).Msg("Successfully created backup")
-		}
-
-		// 根据文件类型处理
-		fileExt := strings.ToLower(filepath.Ext(filePath))
-		var fileUpdated, fileSuccess bool
-		var fileUpdatedKeys, fileDeletedKeys int
-
-		switch {
-		case fileExt == ".vscdb" || fileExt == ".db" || fileExt == ".sqlite" || fileExt == ".sqlite3":
-			// 处理SQLite数据库文件
-			fileUpdated, fileUpdatedKeys, fileDeletedKeys, fileSuccess = e.processSQLiteFile(filePath, telemetryKeys, sessionKeys)
-
-		case fileExt == ".json":
-			// 处理JSON文件
-			fileUpdated, fileUpdatedKeys, fileDeletedKeys, fileSuccess = e.processJSONFile(filePath, telemetryKeys, sessionKeys)
-
-		default:
-			log.Debug().Str("file", filePath).Str("type", fileExt).Msg("Unsupported file type, skipping")
-			continue
-		}
-
-		// 更新统计信息
-		processedFiles++
-		updatedKeys += fileUpdatedKeys
-		deletedKeys += fileDeletedKeys
-		if !fileSuccess {
-			failedFiles++
-		}
-
-		// 如果修改成功，记录日志
-		if fileUpdated {
-			log.Info().Str("file", filePath).Int("updated_keys", fileUpdatedKeys).Int("deleted_keys", fileDeletedKeys).Msg("Successfully modified identifier file")
+// uniqueBackupPath 多次备份同名目录（例如多个 Cache 目录）时追加序号，避免互相覆盖
+func uniqueBackupPath(base, ext string) string {
+	candidate := base + ext
+	for i := 1; ; i++ {
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
 		}
+		candidate = fmt.Sprintf("%s_%d%s", base, i, ext)
 	}
-
-	// 发送标识符修改完成消息
-	e.sendProgress(ProgressUpdate{
-		Type: "telemetry",
-		Message: e.localizeMessage("TelemetryModificationComplete", map[string]interface{}{
-			"Processed": processedFiles,
-			"Updated":   updatedKeys,
-			"Deleted":   deletedKeys,
-			"Failed":    failedFiles,
-		}),
-		Phase:    "telemetry",
-		Progress: 45,
-		AppName:  appName,
-	})
-
-	return nil
 }
 
-// processSQLiteFile 处理单个SQLite文件，返回是否更新成功，更新的键数，删除的键数，以及处理是否成功
-func (e *Engine) processSQLiteFile(dbPath string, telemetryKeys, sessionKeys []string) (bool, int, int, bool) {
-	log.Debug().Str("path", dbPath).Msg("Processing SQLite database")
-
-	// 尝试使用不同的连接参数打开数据库
-	connectionStrings := []string{
-		dbPath + "?_journal=WAL&_timeout=5000",
-		dbPath + "?mode=rw",
-		dbPath, // 简单连接，作为最后尝试
+// createCompressedBackup writes sourcePath to a zip archive.
+// 每个条目都通过 FileInfoHeader 记录权限和修改时间，符号链接按 Info-ZIP 约定保存为链接目标
+func (e *Engine) createCompressedBackup(sourcePath, backupPath string) (string, error) {
+	zipFile, err := os.OpenFile(backupPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, backupFileMode)
+	if err != nil {
+		return "", err
 	}
 
-	for _, connStr := range connectionStrings {
-		db, err := sql.Open("sqlite", connStr)
+	zipWriter := zip.NewWriter(zipFile)
+
+	err = walkBackupSource(sourcePath, func(path, name string, info os.FileInfo) error {
+		header, err := zip.FileInfoHeader(info)
 		if err != nil {
-			log.Debug().Str("connection", connStr).Err(err).Msg("Failed to open database connection")
-			continue
+			return err
 		}
-		defer db.Close()
+		header.Name = name
+		header.Modified = info.ModTime()
 
-		// 检查数据库连接
-		if err := db.Ping(); err != nil {
-			log.Debug().Str("connection", connStr).Err(err).Msg("Failed to ping database")
-			continue
+		if info.IsDir() {
+			header.Name += "/"
+			header.UncompressedSize64 = 0
+			_, err = zipWriter.CreateHeader(header)
+			return err
 		}
 
-		log.Debug().Str("connection", connStr).Msg("Successfully connected to database")
-
-		// 查找ItemTable或类似表
-		tables, err := e.findRelevantTables(db)
+		header.Method = zip.Deflate
+		zipEntry, err := zipWriter.CreateHeader(header)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to find relevant tables")
-			continue
+			return err
 		}
 
-		if len(tables) == 0 {
-			log.Warn().Msg("No processable tables found in database")
-			return false, 0, 0, true // 没有表不算失败
+		if isSymlink(info.Mode()) {
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, err = io.WriteString(zipEntry, linkTarget)
+			return err
 		}
 
-		// 开始事务
-		tx, err := db.Begin()
+		file, err := os.Open(path)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to begin transaction")
-			continue
+			return err
 		}
+		defer file.Close()
 
-		// 生成新ID
-		newMachineID := uuid.New().String()
-		newSessionID := uuid.New().String()
-
-		totalUpdatedKeys := 0
-		totalDeletedKeys := 0
-
-		// 处理每个相关表
-		for _, tableInfo := range tables {
-			tableName := tableInfo.name
-			keyColumn := tableInfo.keyColumn
-			valueColumn := tableInfo.valueColumn
+		_, err = io.Copy(zipEntry, file)
+		return err
+	})
 
-			// 更新telemetry keys
-			for _, key := range telemetryKeys {
-				value := newMachineID
-				if strings.Contains(strings.ToLower(key), "session") {
-					value = newSessionID
-				}
+	if closeErr := zipWriter.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := zipFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(backupPath)
+		return "", err
+	}
 
-				// 安全构造SQL语句
-				updateSQL := fmt.Sprintf("UPDATE %s SET %s = ? WHERE %s = ?",
-					quoteIdentifier(tableName),
-					quoteIdentifier(valueColumn),
-					quoteIdentifier(keyColumn))
+	log.Info().Str("path", backupPath).Msg("Created compressed backup")
+	return backupPath, nil
+}
 
-				result, err := tx.Exec(updateSQL, value, key)
-				if err != nil {
-					log.Debug().Str("table", tableName).Str("key", key).Err(err).Msg("Failed to update key")
-					continue
-				}
+// encryptBackup replaces a backup archive with its encrypted form and returns the new path.
+// 明文归档在加密完成后立即删除
+func (e *Engine) encryptBackup(backupPath string) (string, error) {
+	encryptedPath := uniqueBackupPath(backupPath, encryptedExtension)
+	err := encryptFile(e.session.key, backupPath, encryptedPath)
+	os.Remove(backupPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt backup: %w", err)
+	}
 
-				if affected, err := result.RowsAffected(); err == nil && affected > 0 {
-					totalUpdatedKeys++
-					log.Debug().Str("table", tableName).Str("key", key).Msg("Successfully updated key")
-				}
-			}
+	if e.config.SafetyOptions.VerifyBackups {
+		if err := verifyEncryptedBackup(e.session.key, encryptedPath); err != nil {
+			os.Remove(encryptedPath)
+			return "", err
+		}
+	}
 
-			// 删除session keys
-			for _, key := range sessionKeys {
-				deleteSQL := fmt.Sprintf("DELETE FROM %s WHERE %s = ?",
-					quoteIdentifier(tableName),
-					quoteIdentifier(keyColumn))
+	log.Info().Str("path", encryptedPath).Msg("Encrypted backup")
+	return encryptedPath, nil
+}
 
-				result, err := tx.Exec(deleteSQL, key)
-				if err != nil {
-					log.Debug().Str("table", tableName).Str("key", key).Err(err).Msg("Failed to delete key")
-					continue
-				}
+func (e *Engine) createDirectoryBackup(sourcePath, backupPath string) (string, error) {
+	fileInfo, err := os.Stat(sourcePath)
+	if err != nil {
+		return "", err
+	}
 
-				if affected, err := result.RowsAffected(); err == nil && affected > 0 {
-					totalDeletedKeys++
-					log.Debug().Str("table", tableName).Str("key", key).Msg("Successfully deleted key")
-				}
-			}
-		}
+	if fileInfo.IsDir() {
+		err = copyDirectory(sourcePath, backupPath)
+	} else {
+		err = copyFile(sourcePath, backupPath)
+	}
 
-		// 提交事务
-		if err := tx.Commit(); err != nil {
-			log.Error().Err(err).Msg("Failed to commit transaction")
-			return false, 0, 0, false
-		}
+	if err != nil {
+		return "", err
+	}
 
-		// 如果有更改，执行VACUUM
-		if totalUpdatedKeys > 0 || totalDeletedKeys > 0 {
-			if _, err := db.Exec("VACUUM"); err != nil {
-				log.Warn().Err(err).Msg("Failed to execute VACUUM")
-				// 继续处理，不返回错误
-			}
-			return true, totalUpdatedKeys, totalDeletedKeys, true
-		}
+	log.Info().Str("path", backupPath).Msg("Created directory backup")
+	return backupPath, nil
+}
 
-		return false, 0, 0, true // 没有更改，��成功处理
+// CleanApplication plans the reset of appName and applies the plan right away, see ApplyPlan for the result
+func (e *Engine) CleanApplication(ctx context.Context, appName string) (*CleanResult, error) {
+	plan, err := e.PlanApplication(appName)
+	if err != nil {
+		return nil, err
 	}
-
-	// 所有连接方式都失败
-	return false, 0, 0, false
+	// 生成计划时不做任何修改，此时取消无需报告已完成的项目
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return e.ApplyPlan(ctx, plan)
 }
 
 // TableInfo 表示数据库表的结构信息
@@ -729,495 +602,6 @@ func (e *Engine) analyzeTableStructure(db *sql.DB, tableName string) (TableInfo,
 	return TableInfo{}, false
 }
 
-// processJSONFile 处理单个JSON文件，返回是否更新成功，更新的键数，删除的键数，以及处理是否成功
-func (e *Engine) processJSONFile(jsonPath string, telemetryKeys, sessionKeys []string) (bool, int, int, bool) {
-	log.Debug().Str("path", jsonPath).Msg("处理JSON文件")
-
-	// 1. 创建备份副本以便出错时恢复
-	tempBackupPath := jsonPath + ".bak"
-	err := copyFile(jsonPath, tempBackupPath)
-	if err != nil {
-		log.Debug().Str("path", jsonPath).Err(err).Msg("创建临时备份失败，继续处理")
-		// 继续处理，即使没有备份
-	} else {
-		defer func() {
-			// 如果成功，删除临时备份
-			os.Remove(tempBackupPath)
-		}()
-	}
-
-	// 2. 读取JSON文件，使用更安全的方式
-	data, err := os.ReadFile(jsonPath)
-	if err != nil {
-		log.Error().Str("path", jsonPath).Err(err).Msg("读取JSON文件失败")
-		return false, 0, 0, false
-	}
-
-	// 3. 处理空文件的情况
-	if len(data) == 0 {
-		log.Warn().Str("path", jsonPath).Msg("JSON文件为空")
-		return false, 0, 0, true // 视为成功处理但无需更改
-	}
-
-	// 4. 解析JSON，支持不同的JSON结构
-	var jsonData map[string]interface{}
-	if err := json.Unmarshal(data, &jsonData); err != nil {
-		// 尝试作为JSON数组解析
-		var jsonArray []interface{}
-		if err2 := json.Unmarshal(data, &jsonArray); err2 != nil {
-			log.Error().Str("path", jsonPath).Err(err).Msg("解析JSON失败")
-			return false, 0, 0, false
-		}
-
-		// 不支持处理JSON数组
-		log.Warn().Str("path", jsonPath).Msg("JSON文件是数组格式，不支持处理")
-		return false, 0, 0, true // 视为成功处理但无需更改
-	}
-
-	// 5. 生成新ID
-	newMachineID := uuid.New().String()
-	newSessionID := uuid.New().String()
-
-	// 6. 更新统计
-	updatedKeys := 0
-	deletedKeys := 0
-	modified := false
-
-	// 7. 处理包括嵌套结构的JSON
-	processNestedJSON(jsonData, telemetryKeys, sessionKeys, newMachineID, newSessionID, &updatedKeys, &deletedKeys, &modified)
-
-	// 8. 如果有修改，写回文件
-	if modified {
-		// 使用更美观的缩进格式
-		newData, err := json.MarshalIndent(jsonData, "", "  ")
-		if err != nil {
-			log.Error().Str("path", jsonPath).Err(err).Msg("JSON序列化失败")
-			// 尝试恢复备份
-			if _, err := os.Stat(tempBackupPath); err == nil {
-				if restoreErr := copyFile(tempBackupPath, jsonPath); restoreErr != nil {
-					log.Error().Str("path", jsonPath).Err(restoreErr).Msg("恢复备份失败")
-				} else {
-					log.Info().Str("path", jsonPath).Msg("已从备份恢复原始文件")
-				}
-			}
-			return false, 0, 0, false
-		}
-
-		// 写入文件，保持原始文件权限
-		fileInfo, err := os.Stat(jsonPath)
-		if err != nil {
-			log.Warn().Str("path", jsonPath).Err(err).Msg("获取文件权限失败，使用默认权限")
-		}
-
-		// 使用临时文件并重命名的方式写入，避免文件损坏
-		tempFilePath := jsonPath + ".tmp"
-		err = os.WriteFile(tempFilePath, newData, 0644)
-		if err != nil {
-			log.Error().Str("path", tempFilePath).Err(err).Msg("写入临时文件失败")
-			// 尝试删除临时文件
-			os.Remove(tempFilePath)
-			// 尝试恢复备份
-			if _, err := os.Stat(tempBackupPath); err == nil {
-				if restoreErr := copyFile(tempBackupPath, jsonPath); restoreErr != nil {
-					log.Error().Str("path", jsonPath).Err(restoreErr).Msg("恢复备份失败")
-				} else {
-					log.Info().Str("path", jsonPath).Msg("已从备份恢复原始文件")
-				}
-			}
-			return false, 0, 0, false
-		}
-
-		// 如果有获取到原始权限，则设置相同的权限
-		if fileInfo != nil {
-			if err := os.Chmod(tempFilePath, fileInfo.Mode()); err != nil {
-				log.Warn().Str("path", tempFilePath).Err(err).Msg("设置文件权限失败")
-				// 继续处理，不视为致命错误
-			}
-		}
-
-		// 重命名临时文件，替换原始文件
-		if err := os.Rename(tempFilePath, jsonPath); err != nil {
-			log.Error().Str("from", tempFilePath).Str("to", jsonPath).Err(err).Msg("重命名文件失败")
-			// 尝试删除临时文件
-			os.Remove(tempFilePath)
-			// 尝试恢复备份
-			if _, err := os.Stat(tempBackupPath); err == nil {
-				if restoreErr := copyFile(tempBackupPath, jsonPath); restoreErr != nil {
-					log.Error().Str("path", jsonPath).Err(restoreErr).Msg("恢复备份失败")
-				} else {
-					log.Info().Str("path", jsonPath).Msg("已从备份恢复原始文件")
-				}
-			}
-			return false, 0, 0, false
-		}
-
-		log.Info().
-			Str("path", jsonPath).
-			Int("updated_keys", updatedKeys).
-			Int("deleted_keys", deletedKeys).
-			Msg("成功更新JSON文件")
-		return true, updatedKeys, deletedKeys, true
-	}
-
-	log.Debug().Str("path", jsonPath).Msg("JSON文件无需修改")
-	return false, 0, 0, true // 没有更改，但处理成功
-}
-
-// processNestedJSON 递归处理嵌套的JSON结构
-func processNestedJSON(data map[string]interface{}, telemetryKeys, sessionKeys []string,
-	newMachineID, newSessionID string,
-	updatedKeys, deletedKeys *int, modified *bool) {
-	// 处理当前级别的键
-	for _, key := range telemetryKeys {
-		if val, exists := data[key]; exists {
-			// 检查是否为string类型的值
-			if _, isString := val.(string); isString {
-				if strings.Contains(strings.ToLower(key), "session") {
-					data[key] = newSessionID
-				} else {
-					data[key] = newMachineID
-				}
-				*modified = true
-				*updatedKeys++
-			}
-		}
-	}
-
-	// 处理会话键
-	for _, key := range sessionKeys {
-		if _, exists := data[key]; exists {
-			delete(data, key)
-			*modified = true
-			*deletedKeys++
-		}
-	}
-
-	// 递归处理嵌套的对象
-	for _, val := range data {
-		// 如果值是一个嵌套的map
-		if nestedMap, isMap := val.(map[string]interface{}); isMap {
-			processNestedJSON(nestedMap, telemetryKeys, sessionKeys, newMachineID, newSessionID, updatedKeys, deletedKeys, modified)
-		} else if nestedArray, isArray := val.([]interface{}); isArray {
-			// 如果值是一个数组
-			for _, item := range nestedArray {
-				// 如果数组元素是一个map
-				if nestedItem, isMap := item.(map[string]interface{}); isMap {
-					processNestedJSON(nestedItem, telemetryKeys, sessionKeys, newMachineID, newSessionID, updatedKeys, deletedKeys, modified)
-				}
-			}
-		}
-	}
-}
-
-// cleanDatabases cleans database files
-func (e *Engine) cleanDatabases(appPath, appName string) error {
-	log.Info().Str("app", appName).Str("path", appPath).Msg("开始重置数据库")
-
-	// 首先查找所有数据库文件
-	dbFiles := e.findDatabaseFiles(appPath)
-	totalFiles := len(dbFiles)
-
-	if totalFiles == 0 {
-		log.Warn().Str("app", appName).Msg("没有找到数据库文件")
-		e.sendProgress(ProgressUpdate{
-			Type:     "database",
-			Message:  e.localizeMessage("NoDatabaseFound", map[string]interface{}{}),
-			AppName:  appName,
-			Phase:    "database",
-			Progress: 65,
-		})
-		return nil
-	}
-
-	e.sendProgress(ProgressUpdate{
-		Type:     "database",
-		Message:  e.localizeMessage("FoundDatabases", map[string]interface{}{"Count": totalFiles}),
-		AppName:  appName,
-		Phase:    "database",
-		Progress: 50,
-	})
-
-	// 跟踪处理结果
-	var (
-		processedFiles int
-		cleanedFiles   int
-		totalRecords   int
-		failedFiles    int
-	)
-
-	keywords := e.config.CleaningOptions.DatabaseKeywords
-
-	// 处理每个数据库文件
-	for fileIndex, dbPath := range dbFiles {
-		progress := 50.0 + float64(fileIndex)*15.0/float64(totalFiles+1)
-		e.sendProgress(ProgressUpdate{
-			Type: "database",
-			Message: e.localizeMessage("ProcessingDatabase", map[string]interface{}{
-				"Current":  fileIndex + 1,
-				"Total":    totalFiles,
-				"FileName": filepath.Base(dbPath),
-			}),
-			AppName:  appName,
-			Phase:    "database",
-			Progress: progress,
-		})
-
-		// 检查是否是备份文件
-		if strings.Contains(strings.ToLower(dbPath), "backup") || strings.Contains(dbPath, ".bak") {
-			log.Debug().Str("path", dbPath).Msg("跳过备份文件")
-			continue
-		}
-
-		// 创建备份
-		backupPath, err := e.CreateBackup(dbPath, fmt.Sprintf("%s_database_%s", appName, filepath.Base(dbPath)))
-		if err != nil {
-			log.Warn().Str("file", dbPath).Err(err).Msg("备份数据库失败，继续处理")
-		} else {
-			log.Info().Str("file", dbPath).Str("backup", backupPath).Msg("成功创建数据库备份")
-		}
-
-		// 重置数据库
-		cleaned, recordsAffected, success := e.cleanSQLiteDatabaseAdvanced(dbPath, keywords)
-
-		// 更新统计
-		processedFiles++
-		if cleaned {
-			cleanedFiles++
-			totalRecords += recordsAffected
-		}
-		if !success {
-			failedFiles++
-		}
-
-		if cleaned {
-			log.Info().Str("file", dbPath).Int("records_affected", recordsAffected).Msg("成功重置数据库")
-		}
-	}
-
-	// 发送完成消息
-	e.sendProgress(ProgressUpdate{
-		Type: "database",
-		Message: e.localizeMessage("DatabaseResetComplete", map[string]interface{}{
-			"Reset":   cleanedFiles,
-			"Total":   processedFiles,
-			"Records": totalRecords,
-			"Failed":  failedFiles,
-		}),
-		AppName:  appName,
-		Phase:    "database",
-		Progress: 65,
-	})
-
-	return nil
-}
-
-// cleanSQLiteDatabaseAdvanced 增强版的SQLite数据库重置函数
-func (e *Engine) cleanSQLiteDatabaseAdvanced(dbPath string, keywords []string) (bool, int, bool) {
-	log.Debug().Str("path", dbPath).Msg("重置SQLite数据库")
-
-	// 尝试使用不同的连接参数打开数据库
-	connectionStrings := []string{
-		dbPath + "?_journal=WAL&_timeout=5000",
-		dbPath + "?mode=rw",
-		dbPath, // 简单连接，作为最后尝试
-	}
-
-	for _, connStr := range connectionStrings {
-		db, err := sql.Open("sqlite", connStr)
-		if err != nil {
-			log.Debug().Str("connection", connStr).Err(err).Msg("尝试连接数据库失败")
-			continue
-		}
-		defer db.Close()
-
-		// 检查数据库连接
-		if err := db.Ping(); err != nil {
-			log.Debug().Str("connection", connStr).Err(err).Msg("Ping数据库失败")
-			continue
-		}
-
-		log.Debug().Str("connection", connStr).Msg("成功连接到数据库")
-
-		// 开始事务
-		tx, err := db.Begin()
-		if err != nil {
-			log.Error().Err(err).Msg("开始事务失败")
-			continue
-		}
-
-		// 获取所有表
-		tables, err := tx.Query("SELECT name FROM sqlite_master WHERE type='table'")
-		if err != nil {
-			log.Error().Err(err).Msg("获取表列表失败")
-			tx.Rollback()
-			continue
-		}
-
-		var tableNames []string
-		for tables.Next() {
-			var tableName string
-			if err := tables.Scan(&tableName); err != nil {
-				log.Warn().Err(err).Msg("读取表名失败")
-				continue
-			}
-			// 跳过系统表
-			if !strings.HasPrefix(tableName, "sqlite_") {
-				tableNames = append(tableNames, tableName)
-			}
-		}
-		tables.Close()
-
-		if len(tableNames) == 0 {
-			log.Warn().Str("path", dbPath).Msg("数据库中没有找到用户表")
-			tx.Rollback()
-			return false, 0, true // 没有表不算失败
-		}
-
-		cleanedRecords := 0
-		cachePatterns := e.config.CleaningOptions.CacheTablePatterns
-
-		// 首先重置缓存表（完全删除）
-		for _, tableName := range tableNames {
-			// 检查表名是否安全
-			if !isValidTableName(tableName) {
-				log.Warn().Str("table", tableName).Msg("跳过不安全的表名")
-				continue
-			}
-
-			// 查找匹配缓存模式的表
-			for _, pattern := range cachePatterns {
-				if strings.Contains(strings.ToLower(tableName), pattern) {
-					log.Debug().Str("table", tableName).Str("pattern", pattern).Msg("重置缓存表")
-
-					// 清空整个表
-					deleteSql := fmt.Sprintf("DELETE FROM %s", quoteIdentifier(tableName))
-					result, err := tx.Exec(deleteSql)
-					if err != nil {
-						log.Warn().Str("table", tableName).Err(err).Msg("清空表失败")
-						continue
-					}
-
-					if affected, err := result.RowsAffected(); err == nil && affected > 0 {
-						cleanedRecords += int(affected)
-						log.Info().Str("table", tableName).Int64("records", affected).Msg("清空表成功")
-					}
-					break
-				}
-			}
-		}
-
-		// 然后处理其他表，按关键词重置
-		for _, tableName := range tableNames {
-			// 检查表名是否安全
-			if !isValidTableName(tableName) {
-				continue
-			}
-
-			// 获取表的所有列
-			columnSQL := fmt.Sprintf("PRAGMA table_info(%s)", quoteIdentifier(tableName))
-			colRows, err := tx.Query(columnSQL)
-			if err != nil {
-				log.Warn().Str("table", tableName).Err(err).Msg("获取表列信息失败")
-				continue
-			}
-
-			var columns []string
-			for colRows.Next() {
-				var cid int
-				var name, ctype string
-				var notnull, dfltValue, pk interface{}
-				if err := colRows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
-					continue
-				}
-				// 只处理安全的列名
-				if isValidColumnName(name) {
-					columns = append(columns, name)
-				}
-			}
-			colRows.Close()
-
-			// 对每个列和关键词组合尝试删除记录
-			for _, keyword := range keywords {
-				for _, column := range columns {
-					// 尝试查找包含关键词的记录
-					deleteSql := fmt.Sprintf("DELETE FROM %s WHERE %s LIKE ?",
-						quoteIdentifier(tableName),
-						quoteIdentifier(column))
-					result, err := tx.Exec(deleteSql, "%"+keyword+"%")
-					if err != nil {
-						log.Debug().Str("table", tableName).Str("column", column).Str("keyword", keyword).Err(err).Msg("按关键词删除记录失败")
-						continue
-					}
-
-					if affected, err := result.RowsAffected(); err == nil && affected > 0 {
-						cleanedRecords += int(affected)
-						log.Info().Str("table", tableName).Str("column", column).Str("keyword", keyword).Int64("records", affected).Msg("按关键词删除记录成功")
-					}
-				}
-			}
-
-			// 检查通用的用户/账户列
-			userColumns := []string{"user_id", "account_id", "email", "username", "userid", "accountid"}
-			for _, column := range columns {
-				columnLower := strings.ToLower(column)
-				for _, userCol := range userColumns {
-					if columnLower == userCol || strings.Contains(columnLower, userCol) {
-						log.Debug().Str("table", tableName).Str("column", column).Msg("尝试重置用户相关列")
-
-						// 尝试将字段设为NULL或空值
-						updateSql := fmt.Sprintf("UPDATE %s SET %s = NULL WHERE %s IS NOT NULL",
-							quoteIdentifier(tableName),
-							quoteIdentifier(column),
-							quoteIdentifier(column))
-						result, err := tx.Exec(updateSql)
-						if err != nil {
-							log.Debug().Str("table", tableName).Str("column", column).Err(err).Msg("设置列为NULL失败，尝试清空")
-
-							// 尝试清空值
-							updateSql = fmt.Sprintf("UPDATE %s SET %s = '' WHERE %s != ''",
-								quoteIdentifier(tableName),
-								quoteIdentifier(column),
-								quoteIdentifier(column))
-							result, err = tx.Exec(updateSql)
-							if err != nil {
-								log.Debug().Str("table", tableName).Str("column", column).Err(err).Msg("清空列值失败")
-								continue
-							}
-						}
-
-						if affected, err := result.RowsAffected(); err == nil && affected > 0 {
-							cleanedRecords += int(affected)
-							log.Info().Str("table", tableName).Str("column", column).Int64("records", affected).Msg("重置用户相关列成功")
-						}
-					}
-				}
-			}
-		}
-
-		// 提交事务
-		if err := tx.Commit(); err != nil {
-			log.Error().Err(err).Msg("提交事务失败")
-			tx.Rollback()
-			return false, 0, false
-		}
-
-		// 如果有重置的记录，优化数据库
-		if cleanedRecords > 0 {
-			log.Info().Str("path", dbPath).Msg("优化数据库")
-			if _, err := db.Exec("VACUUM"); err != nil {
-				log.Warn().Err(err).Msg("执行VACUUM失败")
-				// 继续处理，不返回错误
-			}
-			return true, cleanedRecords, true
-		}
-
-		return false, 0, true // 没有记录需要重置，但处理成功
-	}
-
-	// 所有连接方式都失败
-	return false, 0, false
-}
-
 // isValidTableName 检查表名是否安全有效
 func isValidTableName(name string) bool {
 	// 表名只能包含字母、数字、下划线，且不能以数字开头
@@ -1258,214 +642,8 @@ func (e *Engine) getTableColumns(db *sql.DB, tableName string) ([]string, error)
 	return columns, nil
 }
 
-// cleanCache cleans cache directories
-func (e *Engine) cleanCache(appPath, appName string) error {
-	cacheDirs := e.config.CleaningOptions.CacheDirectories
-
-	e.sendProgress(ProgressUpdate{
-		Type:     "cache",
-		Message:  e.localizeMessage("SearchingCacheDirectories", map[string]interface{}{"Count": len(cacheDirs)}),
-		AppName:  appName,
-		Phase:    "cache",
-		Progress: 80,
-	})
-
-	// 为每个缓存目录类型创建统计信息
-	stats := make(map[string]*CacheStats)
-	for _, dir := range cacheDirs {
-		stats[dir] = &CacheStats{}
-	}
-
-	// 递归查找所有缓存目录
-	allFoundDirs := []string{}
-
-	// 使用更精确的搜索方法
-	for dirIndex, dirName := range cacheDirs {
-		progress := 80.0 + float64(dirIndex)*5.0/float64(len(cacheDirs))
-
-		e.sendProgress(ProgressUpdate{
-			Type:     "cache",
-			Message:  e.localizeMessage("SearchingCacheDirectory", map[string]interface{}{"DirName": dirName}),
-			AppName:  appName,
-			Phase:    "cache",
-			Progress: progress,
-		})
-
-		// 查找匹配的目录
-		foundDirs := e.findDirectoriesRecursive(appPath, []string{dirName})
-
-		if len(foundDirs) > 0 {
-			log.Info().Int("count", len(foundDirs)).Str("dir_type", dirName).Msgf("Found %d %s directories", len(foundDirs), dirName)
-			for _, dir := range foundDirs {
-				log.Debug().Str("type", dirName).Str("path", dir).Msg("Found cache directory")
-			}
-		} else {
-			log.Debug().Str("type", dirName).Msg("No directories found")
-		}
-
-		allFoundDirs = append(allFoundDirs, foundDirs...)
-
-		stats[dirName].DirCount = len(foundDirs)
-
-		// 计算每个目录类型的总大小
-		for _, dir := range foundDirs {
-			size := e.GetDirectorySize(dir)
-			stats[dirName].TotalSize += size
-		}
-	}
-
-	// 如果没有找到任何缓存目录，返回提示信息
-	if len(allFoundDirs) == 0 {
-		log.Warn().Str("app", appName).Str("path", appPath).Msg("No cache directories found")
-		e.sendProgress(ProgressUpdate{
-			Type:     "cache",
-			Message:  e.localizeMessage("NoCacheFound", map[string]interface{}{}),
-			AppName:  appName,
-			Phase:    "cache",
-			Progress: 100,
-		})
-		return nil
-	}
-
-	// 开始重置缓存目录
-	e.sendProgress(ProgressUpdate{
-		Type:     "cache",
-		Message:  e.localizeMessage("StartingCacheReset", map[string]interface{}{"Count": len(allFoundDirs)}),
-		AppName:  appName,
-		Phase:    "cache",
-		Progress: 85,
-	})
-
-	// 按目录类型重置缓存
-	for dirIndex, dirName := range cacheDirs {
-		foundDirs := e.findDirectoriesRecursive(appPath, []string{dirName})
-		if len(foundDirs) == 0 {
-			continue
-		}
-
-		e.sendProgress(ProgressUpdate{
-			Type: "cache",
-			Message: e.localizeMessage("CacheDirectoryFound", map[string]interface{}{
-				"DirName": dirName,
-				"Count":   len(foundDirs),
-			}),
-			AppName:  appName,
-			Phase:    "cache",
-			Progress: 85 + float64(dirIndex)*10.0/float64(len(cacheDirs)*2),
-		})
-
-		for i, dir := range foundDirs {
-			if _, err := os.Stat(dir); os.IsNotExist(err) {
-				continue
-			}
-
-			subProgress := 85 + float64(dirIndex)*10.0/float64(len(cacheDirs)) +
-				float64(i)*5.0/float64(len(foundDirs)*len(cacheDirs))
-
-			e.sendProgress(ProgressUpdate{
-				Type: "cache",
-				Message: e.localizeMessage("ResettingCacheDirectory", map[string]interface{}{
-					"DirName":     dirName,
-					"Current":     i + 1,
-					"Total":       len(foundDirs),
-					"DirBaseName": filepath.Base(dir),
-				}),
-				AppName:  appName,
-				Phase:    "cache",
-				Progress: subProgress,
-			})
-
-			sizeBefore := e.GetDirectorySize(dir)
-
-			// 创建备份
-			backupName := fmt.Sprintf("%s_cache_%s", appName, strings.ReplaceAll(filepath.Base(dir), "/", "_"))
-			_, err := e.CreateBackup(dir, backupName)
-			if err != nil {
-				log.Warn().Str("dir", dir).Err(err).Msg("Failed to create backup")
-			}
-
-			// 清空目录内容
-			if e.dryRun {
-				log.Info().Str("dir", dir).Str("size", e.FormatSize(sizeBefore)).Msg("Would clear cache directory")
-			} else {
-				if err := e.clearDirectoryContents(dir); err != nil {
-					log.Error().Str("dir", dir).Err(err).Msg("Failed to clear cache directory")
-				} else {
-					stats[dirName].CleanedDirs++
-					log.Info().
-						Str("dir", dir).
-						Str("size_freed", e.FormatSize(sizeBefore)).
-						Msg("Cleared cache directory")
-				}
-
-				// 验证重置结果
-				sizeAfter := e.GetDirectorySize(dir)
-				if sizeAfter > 0 {
-					log.Warn().
-						Str("dir", dir).
-						Str("remaining_size", e.FormatSize(sizeAfter)).
-						Msg("Directory not completely cleared")
-
-					// 尝试再次重置
-					log.Info().Str("dir", dir).Msg("Attempting second cleanup pass")
-					if err := e.clearDirectoryContents(dir); err != nil {
-						log.Error().Str("dir", dir).Err(err).Msg("Failed second cleanup attempt")
-					} else {
-						finalSize := e.GetDirectorySize(dir)
-						if finalSize < sizeAfter {
-							log.Info().
-								Str("dir", dir).
-								Str("before", e.FormatSize(sizeAfter)).
-								Str("after", e.FormatSize(finalSize)).
-								Msg("Second cleanup pass improved results")
-						}
-					}
-				}
-			}
-		}
-	}
-
-	// 生成并记录总结报告
-	var totalSize int64
-	var totalCleanedDirs int
-
-	for dirName, stat := range stats {
-		if stat.DirCount > 0 {
-			log.Info().
-				Str("directory_type", dirName).
-				Int("directories", stat.DirCount).
-				Int("cleaned", stat.CleanedDirs).
-				Str("size_freed", e.FormatSize(stat.TotalSize)).
-				Msgf("Cache stats: %s", dirName)
-
-			totalSize += stat.TotalSize
-			totalCleanedDirs += stat.CleanedDirs
-		}
-	}
-
-	log.Info().
-		Str("app", appName).
-		Int("directories_cleaned", totalCleanedDirs).
-		Str("total_size_freed", e.FormatSize(totalSize)).
-		Msg("Total cache cleaning results")
-
-	// 发送最终的完成进度
-	e.sendProgress(ProgressUpdate{
-		Type: "cache",
-		Message: e.localizeMessage("CacheResetComplete", map[string]interface{}{
-			"DirCount": totalCleanedDirs,
-			"Size":     e.FormatSize(totalSize),
-		}),
-		AppName:  appName,
-		Phase:    "cache",
-		Progress: 100,
-	})
-
-	return nil
-}
-
 // clearDirectoryContents clears all contents of a directory
-func (e *Engine) clearDirectoryContents(directory string) error {
+func (e *Engine) clearDirectoryContents(ctx context.Context, directory string) error {
 	entries, err := os.ReadDir(directory)
 	if err != nil {
 		return fmt.Errorf("failed to read directory %s: %w", directory, err)
@@ -1475,6 +653,11 @@ func (e *Engine) clearDirectoryContents(directory string) error {
 	var removedFiles, removedDirs int
 
 	for _, entry := range entries {
+		// 取消时停在条目之间，已删除的条目不会恢复，未处理的条目保持原样
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		path := filepath.Join(directory, entry.Name())
 
 		// 尝试获取文件信息，但如果失败也继续处理
@@ -1506,7 +689,11 @@ func (e *Engine) clearDirectoryContents(directory string) error {
 			subEntries, err := os.ReadDir(path)
 			if err == nil && len(subEntries) > 0 {
 				// 如果目录不为空，先递归清空
-				if subErr := e.clearDirectoryContents(path); subErr != nil {
+				subErr := e.clearDirectoryContents(ctx, path)
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					return ctxErr
+				}
+				if subErr != nil {
 					log.Debug().
 						Str("path", path).
 						Err(subErr).
@@ -1757,7 +944,8 @@ func (e *Engine) findDatabaseFiles(root string) []string {
 	return found
 }
 
-// cleanOldBackups cleans old backups based on retention policy
+// cleanOldBackups cleans old backups based on retention policy.
+// 备份会话作为一个整体过期，不会出现一次运行的备份只被删除一半的情况
 func (e *Engine) cleanOldBackups() {
 	retentionDays := e.config.BackupOptions.RetentionDays
 	if retentionDays <= 0 {
@@ -1772,13 +960,32 @@ func (e *Engine) cleanOldBackups() {
 	}
 
 	for _, entry := range entries {
+		path := filepath.Join(e.backupBaseDir, entry.Name())
+
+		if entry.Name() == backupStoreDirName {
+			continue
+		}
+
+		if _, isApp := e.config.Applications[entry.Name()]; isApp && entry.IsDir() {
+			e.cleanOldSessions(path, cutoffTime)
+			continue
+		}
+
+		// 旧版本直接保存在备份根目录下的备份，按修改时间逐个清理；
+		// 备份目录可能是共享目录，名称不符合旧版本备份格式的条目不是本工具创建的，不能删除
+		if !e.isLegacyBackup(entry.Name()) {
+			continue
+		}
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
 
 		if info.ModTime().Before(cutoffTime) {
-			path := filepath.Join(e.backupBaseDir, entry.Name())
+			if e.dryRun {
+				log.Info().Str("path", path).Msg("Would remove old backup")
+				continue
+			}
 			if err := os.RemoveAll(path); err != nil {
 				log.Warn().Str("path", path).Err(err).Msg("Failed to remove old backup")
 			} else {
@@ -1786,6 +993,52 @@ func (e *Engine) cleanOldBackups() {
 			}
 		}
 	}
+
+	// 过期会话删除后，回收不再被任何清单引用的去重对象
+	e.collectStoreGarbage()
+}
+
+// legacyBackupPattern 旧版本的备份名称：<app>_<phase>_<name>_20060102_150405，zip 备份带 .zip 后缀
+var legacyBackupPattern = regexp.MustCompile(`^([^_]+)_(telemetry|database|cache)_.+_\d{8}_\d{6}(\.zip)?$`)
+
+// isLegacyBackup reports whether name is a backup written by older versions directly into the backup directory
+func (e *Engine) isLegacyBackup(name string) bool {
+	match := legacyBackupPattern.FindStringSubmatch(name)
+	if match == nil {
+		return false
+	}
+	_, isApp := e.config.Applications[match[1]]
+	return isApp
+}
+
+// cleanOldSessions removes every backup session of an application created before cutoffTime
+func (e *Engine) cleanOldSessions(appBackupDir string, cutoffTime time.Time) {
+	sessions, err := os.ReadDir(appBackupDir)
+	if err != nil {
+		return
+	}
+
+	for _, session := range sessions {
+		if !session.IsDir() {
+			continue
+		}
+
+		sessionDir := filepath.Join(appBackupDir, session.Name())
+		createdAt := time.Time{}
+		if manifest, err := readManifest(sessionDir); err == nil {
+			createdAt = manifest.CreatedAt
+		} else if info, err := session.Info(); err == nil {
+			createdAt = info.ModTime()
+		}
+
+		if createdAt.IsZero() || !createdAt.Before(cutoffTime) {
+			continue
+		}
+
+		if err := e.removeSessionDir(sessionDir); err != nil {
+			log.Warn().Str("session", sessionDir).Err(err).Msg("Failed to remove old backup session")
+		}
+	}
 }
 
 // localizeMessage 使用国际化键和模板数据生成本地化消息
@@ -1815,36 +1068,37 @@ func (e *Engine) GetBackupDirectory() string {
 	return e.backupBaseDir
 }
 
-// GenerateCacheCleaningReport 生成缓存重置报告
-func (e *Engine) GenerateCacheCleaningReport(appName string, stats map[string]*CacheStats) string {
+// GenerateCacheCleaningReport 根据重置结果生成缓存重置报告；缓存阶段没有运行时返回空字符串
+func (e *Engine) GenerateCacheCleaningReport(result *CleanResult) string {
+	phase := result.Phase(PhaseCache)
+	if phase == nil {
+		return ""
+	}
+
 	var report strings.Builder
 
 	report.WriteString(e.localizeMessage("CacheReportTitle", map[string]interface{}{
-		"AppName": appName,
+		"AppName": result.AppName,
 	}) + "\n")
 
-	var totalDirs, totalCleanedDirs int
-	var totalSize int64
-
-	for dirName, stat := range stats {
+	var totalDirs int
+	for _, stat := range phase.CacheDirs {
 		if stat.DirCount > 0 {
 			report.WriteString(e.localizeMessage("CacheReportItem", map[string]interface{}{
-				"DirName": dirName,
+				"DirName": stat.Name,
 				"Cleaned": stat.CleanedDirs,
 				"Total":   stat.DirCount,
 				"Size":    e.FormatSize(stat.TotalSize),
 			}) + "\n")
 
 			totalDirs += stat.DirCount
-			totalCleanedDirs += stat.CleanedDirs
-			totalSize += stat.TotalSize
 		}
 	}
 
 	report.WriteString(e.localizeMessage("CacheReportTotal", map[string]interface{}{
-		"Cleaned": totalCleanedDirs,
+		"Cleaned": phase.DirsCleared,
 		"Total":   totalDirs,
-		"Size":    e.FormatSize(totalSize),
+		"Size":    e.FormatSize(phase.BytesFreed),
 	}) + "\n")
 
 	return report.String()
@@ -1874,6 +1128,8 @@ func (e *Engine) DiscoverCacheInfo(appPath, appName string) map[string]int64 {
 }
 
 // Helper functions
+
+// copyFile copies src to dst, keeping the source file's permissions and modification time
 func copyFile(src, dst string) error {
 	sourceFile, err := os.Open(src)
 	if err != nil {
@@ -1881,18 +1137,20 @@ func copyFile(src, dst string) error {
 	}
 	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
+	info, err := sourceFile.Stat()
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
 
-	_, err = io.Copy(destFile, sourceFile)
-	return err
+	return writeFileFrom(dst, sourceFile, info.Mode(), info.ModTime())
 }
 
+// copyDirectory copies the tree below src to dst, preserving modes, modification times,
+// symlinks and empty directories
 func copyDirectory(src, dst string) error {
-	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+	var tree treeWriter
+
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -1904,12 +1162,28 @@ func copyDirectory(src, dst string) error {
 
 		destPath := filepath.Join(dst, relPath)
 
-		if info.IsDir() {
-			return os.MkdirAll(destPath, info.Mode())
+		switch {
+		case info.IsDir():
+			return tree.addDir(destPath, info.Mode(), info.ModTime())
+		case isSymlink(info.Mode()):
+			linkTarget, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			tree.addSymlink(destPath, linkTarget)
+			return nil
+		case !info.Mode().IsRegular():
+			log.Debug().Str("path", path).Msg("Skipping special file")
+			return nil
 		}
 
 		return copyFile(path, destPath)
 	})
+	if err != nil {
+		return err
+	}
+
+	return tree.finish()
 }
 
 // min 返回两个整数中的较小值
@@ -0,0 +1,89 @@
+package cleaner
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// maxSampleKeys 每个列和关键词组合最多列出的示例键
+const maxSampleKeys = 3
+
+// RowEstimate is how many rows of a table one column and keyword match before anything is deleted.
+// 同一行可能匹配多个组合，因此各组合的行数之和可能大于 Action.Count
+type RowEstimate struct {
+	Column     string   `json:"column,omitempty"`
+	Keyword    string   `json:"keyword,omitempty"`
+	Rows       int64    `json:"rows"`
+	SampleKeys []string `json:"sample_keys,omitempty"`
+}
+
+// estimateKeywordRows counts the rows of tableName matching each column and keyword with SELECT COUNT(*)
+func (e *Engine) estimateKeywordRows(db *sql.DB, tableName string, columns, keywords []string) []RowEstimate {
+	keyColumn := e.sampleKeyColumn(db, tableName)
+
+	var estimates []RowEstimate
+	for _, keyword := range keywords {
+		for _, column := range columns {
+			where := fmt.Sprintf("%s LIKE ?", quoteIdentifier(column))
+			pattern := "%" + keyword + "%"
+
+			rows := countRows(db, fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", quoteIdentifier(tableName), where), pattern)
+			if rows == 0 {
+				continue
+			}
+
+			estimates = append(estimates, RowEstimate{
+				Column:     column,
+				Keyword:    keyword,
+				Rows:       rows,
+				SampleKeys: sampleKeys(db, tableName, keyColumn, where, pattern),
+			})
+		}
+	}
+	return estimates
+}
+
+// estimateTableRows 整表清空的缓存表只有一项估算
+func (e *Engine) estimateTableRows(db *sql.DB, tableName string, rows int64) []RowEstimate {
+	keyColumn := e.sampleKeyColumn(db, tableName)
+	return []RowEstimate{{Rows: rows, SampleKeys: sampleKeys(db, tableName, keyColumn, "1", nil)}}
+}
+
+// sampleKeyColumn 键值表使用其键列，其他表使用 rowid
+func (e *Engine) sampleKeyColumn(db *sql.DB, tableName string) string {
+	if info, found := e.analyzeTableStructure(db, tableName); found {
+		return quoteIdentifier(info.keyColumn)
+	}
+	return "rowid"
+}
+
+// sampleKeys 返回最多 maxSampleKeys 个匹配行的键，过长的键会被截断
+func sampleKeys(db *sql.DB, tableName, keyColumn, where string, arg interface{}) []string {
+	query := fmt.Sprintf("SELECT CAST(%s AS TEXT) FROM %s WHERE %s LIMIT %d", keyColumn, quoteIdentifier(tableName), where, maxSampleKeys)
+
+	var args []interface{}
+	if arg != nil {
+		args = append(args, arg)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		log.Debug().Str("table", tableName).Err(err).Msg("Failed to read sample keys")
+		return nil
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key sql.NullString
+		if err := rows.Scan(&key); err != nil || !key.Valid {
+			continue
+		}
+		value := key.String
+		if runes := []rune(value); len(runes) > maxDiffValueLength {
+			value = string(runes[:maxDiffValueLength]) + "..."
+		}
+		keys = append(keys, value)
+	}
+	return keys
+}
@@ -0,0 +1,211 @@
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Phase is one step of a reset. Plan only reads the application data and adds actions to the plan;
+// Apply carries out the actions of the phase that are left after review.
+// 额外的阶段通过 RegisterPhase 注册，并在配置的 cleaning_options.phases 中按名称启用
+type Phase interface {
+	// Name identifies the phase in plans, configuration and backups
+	Name() string
+	// Plan adds the actions of the phase to plan, 不得修改任何文件
+	Plan(e *Engine, plan *Plan) error
+	// Apply carries out actions, which all belong to this phase and are in plan order.
+	// 应在文件之间检查 ctx，取消时返回 ctx.Err()，并保证正在处理的文件完整处理或保持原样。
+	// 对每个文件或目录：先调用 ApplyBackups，修改前调用 MarkTouched，完整处理后调用 MarkDone，
+	// 失败时调用 RecordFailure 并继续下一项；计数写入 CurrentResult。
+	// 不调用这些方法的修改不会被事务模式撤销，也不会出现在取消报告和 CleanResult 中
+	Apply(ctx context.Context, e *Engine, plan *Plan, actions []Action) error
+}
+
+// DefaultPhases are the built-in phases, run in this order when the configuration lists none
+var DefaultPhases = []string{PhaseTelemetry, PhaseDatabase, PhaseCache}
+
+// phaseStart 内置阶段开始时的进度消息和进度；其他阶段使用 RunningPhase 并保持当前进度
+var phaseStart = map[string]struct {
+	messageID string
+	progress  float64
+}{
+	PhaseTelemetry: {"ModifyingTelemetry", 20},
+	PhaseDatabase:  {"ResettingDatabase", 50},
+	PhaseCache:     {"ResettingCache", 80},
+}
+
+var (
+	phasesMu    sync.RWMutex
+	phases      = make(map[string]Phase)
+	phasesOrder []string
+)
+
+func init() {
+	RegisterPhase(telemetryPhase{})
+	RegisterPhase(databasePhase{})
+	RegisterPhase(cachePhase{})
+}
+
+// RegisterPhase makes a phase available by its name. It panics if the name is empty or already registered,
+// 与 database/sql.Register 一样应在 init 中调用
+func RegisterPhase(phase Phase) {
+	phasesMu.Lock()
+	defer phasesMu.Unlock()
+
+	name := phase.Name()
+	if name == "" {
+		panic("cleaner: RegisterPhase with empty name")
+	}
+	if _, exists := phases[name]; exists {
+		panic("cleaner: RegisterPhase called twice for phase " + name)
+	}
+	phases[name] = phase
+	phasesOrder = append(phasesOrder, name)
+}
+
+// LookupPhase returns the registered phase called name
+func LookupPhase(name string) (Phase, bool) {
+	phasesMu.RLock()
+	defer phasesMu.RUnlock()
+	phase, exists := phases[name]
+	return phase, exists
+}
+
+// RegisteredPhases returns the names of all registered phases in registration order, the built-in phases first
+func RegisteredPhases() []string {
+	phasesMu.RLock()
+	defer phasesMu.RUnlock()
+	return append([]string(nil), phasesOrder...)
+}
+
+// resolvePhases looks up names in order and rejects unknown and repeated phases
+func resolvePhases(names []string) ([]Phase, error) {
+	var resolved []Phase
+	seen := make(map[string]bool)
+	for _, name := range names {
+		phase, exists := LookupPhase(name)
+		if !exists {
+			return nil, fmt.Errorf("unknown phase %q (registered: %s)", name, strings.Join(RegisteredPhases(), ", "))
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("phase %q is listed twice", name)
+		}
+		seen[name] = true
+		resolved = append(resolved, phase)
+	}
+	return resolved, nil
+}
+
+// SetPhases makes the engine run only the named phases for every application, in the given order,
+// overriding enabled_phases and cleaning_options.phases. 传入空列表恢复使用配置
+func (e *Engine) SetPhases(names []string) error {
+	if _, err := resolvePhases(names); err != nil {
+		return err
+	}
+	e.phases = append([]string(nil), names...)
+	return nil
+}
+
+// EnabledPhases returns the names of the phases a reset of appName runs, in order:
+// those set with SetPhases, else the application's enabled_phases, else cleaning_options.phases, else DefaultPhases
+// 返回副本，调用方修改结果不会影响引擎和配置
+func (e *Engine) EnabledPhases(appName string) []string {
+	names := DefaultPhases
+	if len(e.phases) > 0 {
+		names = e.phases
+	} else if appConfig, exists := e.config.Applications[appName]; exists && len(appConfig.EnabledPhases) > 0 {
+		names = appConfig.EnabledPhases
+	} else if len(e.config.CleaningOptions.Phases) > 0 {
+		names = e.config.CleaningOptions.Phases
+	}
+	return append([]string(nil), names...)
+}
+
+// isBuiltinPhase 内置阶段只使用内置的动作类型，validatePlan 会检查这些动作是否完整
+func isBuiltinPhase(name string) bool {
+	_, builtin := phaseStart[name]
+	return builtin
+}
+
+// IsDryRun reports whether the engine only reports changes instead of writing them
+func (e *Engine) IsDryRun() bool {
+	return e.dryRun
+}
+
+// PlanBackup adds a backup of path to plan if backups are enabled and the backup policy of phase wants one.
+// 供额外的阶段在修改 path 的动作之前调用
+func (e *Engine) PlanBackup(plan *Plan, phase, path, backupName string) {
+	e.planBackup(plan, phase, "", path, backupName)
+}
+
+// ApplyBackups runs the backup actions among actions and reports whether the other actions may run
+func (e *Engine) ApplyBackups(actions []Action) bool {
+	return e.applyBackups(actions)
+}
+
+// MarkTouched records that the running phase is about to modify path, 事务模式失败或取消时用本次的备份恢复 path
+func (e *Engine) MarkTouched(path string) {
+	e.markTouched(path)
+}
+
+// MarkDone records that path was completely processed; it is listed in CancelledError.Done. dry-run 时不记录
+func (e *Engine) MarkDone(path string) {
+	if !e.dryRun {
+		e.markDone(path)
+	}
+}
+
+// RecordFailure adds path to the failures of the running phase.
+// cause 为 ErrFileMissing、ErrBackupFailed、ErrModifyFailed 或 ErrClearFailed 之一，err 为具体错误，可为 nil
+func (e *Engine) RecordFailure(path string, cause, err error) {
+	e.recordFailure(path, cause, err)
+}
+
+// CurrentResult returns the result of the running phase, to which Apply adds its counts; nil outside ApplyPlan
+func (e *Engine) CurrentResult() *PhaseResult {
+	return e.current
+}
+
+// telemetryPhase 修改遥测 ID 并删除会话键
+type telemetryPhase struct{}
+
+func (telemetryPhase) Name() string { return PhaseTelemetry }
+
+func (telemetryPhase) Plan(e *Engine, plan *Plan) error {
+	e.planTelemetry(plan, plan.AppPath)
+	return nil
+}
+
+func (telemetryPhase) Apply(ctx context.Context, e *Engine, plan *Plan, actions []Action) error {
+	return e.applyTelemetry(ctx, plan.AppName, actions)
+}
+
+// databasePhase 清理数据库中的缓存表、匹配关键词的记录和用户列
+type databasePhase struct{}
+
+func (databasePhase) Name() string { return PhaseDatabase }
+
+func (databasePhase) Plan(e *Engine, plan *Plan) error {
+	e.planDatabases(plan, plan.AppPath)
+	return nil
+}
+
+func (databasePhase) Apply(ctx context.Context, e *Engine, plan *Plan, actions []Action) error {
+	return e.applyDatabases(ctx, plan.AppName, actions)
+}
+
+// cachePhase 清空配置中的缓存目录
+type cachePhase struct{}
+
+func (cachePhase) Name() string { return PhaseCache }
+
+func (cachePhase) Plan(e *Engine, plan *Plan) error {
+	e.planCache(plan, plan.AppPath)
+	return nil
+}
+
+func (cachePhase) Apply(ctx context.Context, e *Engine, plan *Plan, actions []Action) error {
+	return e.applyCache(ctx, plan.AppName, actions)
+}
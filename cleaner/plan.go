@@ -0,0 +1,767 @@
+package cleaner
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PlanVersion is the version of the plan file format written by WritePlan
+const PlanVersion = 1
+
+// Kinds of actions in a cleaning plan
+const (
+	ActionBackup      = "backup"
+	ActionUpdateKey   = "update_key"
+	ActionDeleteKey   = "delete_key"
+	ActionDeleteRows  = "delete_rows"
+	ActionClearColumn = "clear_column"
+	ActionClearDir    = "clear_dir"
+)
+
+// userColumns 数据库阶段会清空名称包含这些片段的列
+var userColumns = []string{"user_id", "account_id", "email", "username", "userid", "accountid"}
+
+// Plan lists every change a reset of one application would make.
+// 计划只在生成时读取文件，不做任何修改；ApplyPlan 按计划中的顺序执行
+type Plan struct {
+	Version   int       `json:"version"`
+	AppName   string    `json:"app_name"`
+	AppPath   string    `json:"app_path"`
+	CreatedAt time.Time `json:"created_at"`
+	// Phases 生成计划时启用的阶段，按执行顺序排列；旧的计划文件中没有此字段
+	Phases  []string `json:"phases,omitempty"`
+	Actions []Action `json:"actions"`
+}
+
+// Action is a single change in a plan. 未使用的字段在 JSON 中省略
+type Action struct {
+	ID    int    `json:"id"`
+	Phase string `json:"phase"`
+	Type  string `json:"type"`
+	Path  string `json:"path"`
+
+	// update_key / delete_key: Table 中 KeyColumn = Key 的行；Table 为空时 Key 是 JSON 文件中任意层级的键
+	Table       string `json:"table,omitempty"`
+	KeyColumn   string `json:"key_column,omitempty"`
+	ValueColumn string `json:"value_column,omitempty"`
+	Key         string `json:"key,omitempty"`
+	Value       string `json:"value,omitempty"` // update_key 写入的新 ID，生成计划时确定
+
+	// delete_rows: Columns 中任一列包含 Keywords 中任一关键词的行；Columns 为空时删除整个表的内容
+	Columns  []string `json:"columns,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+	// clear_column: 将 Table 的 Column 置为 NULL
+	Column string `json:"column,omitempty"`
+
+	// delete_rows: 删除前按表、列和关键词统计的行数
+	Estimates []RowEstimate `json:"estimates,omitempty"`
+
+	CacheDir   string `json:"cache_dir,omitempty"`   // clear_dir 匹配到的 cache_directories 条目
+	BackupName string `json:"backup_name,omitempty"` // backup
+
+	Size  int64 `json:"size,omitempty"`  // backup / clear_dir 的字节数
+	Count int64 `json:"count,omitempty"` // 生成计划时匹配的行数或键出现次数
+}
+
+// Description returns a short English description of the action for listings
+func (a Action) Description() string {
+	switch a.Type {
+	case ActionBackup:
+		return "back up " + a.Path
+	case ActionUpdateKey:
+		if a.Table == "" {
+			return fmt.Sprintf("set %s to a new ID", a.Key)
+		}
+		return fmt.Sprintf("set %s in %s to a new ID", a.Key, a.Table)
+	case ActionDeleteKey:
+		if a.Table == "" {
+			return "delete " + a.Key
+		}
+		return fmt.Sprintf("delete %s from %s", a.Key, a.Table)
+	case ActionDeleteRows:
+		if len(a.Columns) == 0 {
+			return fmt.Sprintf("delete all %d rows of %s", a.Count, a.Table)
+		}
+		return fmt.Sprintf("delete %d rows of %s matching %s", a.Count, a.Table, strings.Join(a.Keywords, ", "))
+	case ActionClearColumn:
+		return fmt.Sprintf("clear %s.%s in %d rows", a.Table, a.Column, a.Count)
+	case ActionClearDir:
+		return fmt.Sprintf("clear %s (%s)", a.Path, a.CacheDir)
+	}
+	return a.Type
+}
+
+// PhaseNames returns the phases of the plan in the order they run
+func (p *Plan) PhaseNames() []string {
+	if len(p.Phases) == 0 {
+		return DefaultPhases
+	}
+	return p.Phases
+}
+
+// PhaseActions returns the actions of phase in plan order
+func (p *Plan) PhaseActions(phase string) []Action {
+	var actions []Action
+	for _, action := range p.Actions {
+		if action.Phase == phase {
+			actions = append(actions, action)
+		}
+	}
+	return actions
+}
+
+// Filter returns a copy of the plan with only the actions keep accepts.
+// 某个路径的修改全部被去掉时，其备份动作也一并去掉；保留修改而去掉备份则会在没有备份的情况下修改
+func (p *Plan) Filter(keep func(Action) bool) *Plan {
+	changed := make(map[string]bool)
+	for _, action := range p.Actions {
+		if action.Type != ActionBackup && keep(action) {
+			changed[action.Phase+"\x00"+action.Path] = true
+		}
+	}
+
+	filtered := *p
+	filtered.Actions = nil
+	for _, action := range p.Actions {
+		if keep(action) && changed[action.Phase+"\x00"+action.Path] {
+			filtered.Actions = append(filtered.Actions, action)
+		}
+	}
+	return &filtered
+}
+
+// OnlyPhases returns a copy of the plan reduced to the named phases; phases the plan does not contain are ignored
+func (p *Plan) OnlyPhases(names []string) *Plan {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	filtered := p.Filter(func(action Action) bool { return wanted[action.Phase] })
+	filtered.Phases = nil
+	for _, name := range p.PhaseNames() {
+		if wanted[name] {
+			filtered.Phases = append(filtered.Phases, name)
+		}
+	}
+	return filtered
+}
+
+// Add appends actions to the plan and numbers them
+func (p *Plan) Add(actions ...Action) {
+	for _, action := range actions {
+		action.ID = len(p.Actions) + 1
+		p.Actions = append(p.Actions, action)
+	}
+}
+
+// WritePlan saves plan as indented JSON, readable only by the current user
+func WritePlan(path string, plan *Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, backupFileMode)
+}
+
+// ReadPlan loads a plan written by WritePlan
+func ReadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("invalid plan file %s: %w", path, err)
+	}
+	if plan.Version != PlanVersion {
+		return nil, fmt.Errorf("unsupported plan version %d", plan.Version)
+	}
+	return &plan, nil
+}
+
+// PlanApplication works out what resetting appName would change without modifying anything
+func (e *Engine) PlanApplication(appName string) (*Plan, error) {
+	appPath, exists := e.appDataPaths[appName]
+	if !exists || appPath == "" {
+		return nil, fmt.Errorf(e.localizeMessage("AppNotFound", map[string]interface{}{"AppName": appName}))
+	}
+
+	phases, err := resolvePhases(e.EnabledPhases(appName))
+	if err != nil {
+		return nil, err
+	}
+
+	// 初始缓存扫描
+	e.sendProgress(ProgressUpdate{
+		Type:     "discover",
+		Message:  e.localizeMessage("AnalyzeAppData", nil),
+		AppName:  appName,
+		Progress: 10,
+	})
+
+	// 发现缓存信息
+	cacheInfo := e.DiscoverCacheInfo(appPath, appName)
+	var totalCacheSize int64
+	for _, size := range cacheInfo {
+		totalCacheSize += size
+	}
+
+	e.sendProgress(ProgressUpdate{
+		Type:     "discover",
+		Message:  e.localizeMessage("FoundCacheInfo", map[string]interface{}{"Count": len(cacheInfo), "Size": e.FormatSize(totalCacheSize)}),
+		AppName:  appName,
+		Progress: 15,
+	})
+
+	plan := &Plan{
+		Version:   PlanVersion,
+		AppName:   appName,
+		AppPath:   appPath,
+		CreatedAt: time.Now(),
+	}
+
+	for _, phase := range phases {
+		plan.Phases = append(plan.Phases, phase.Name())
+		if err := phase.Plan(e, plan); err != nil {
+			return nil, fmt.Errorf("failed to plan phase %s: %w", phase.Name(), err)
+		}
+	}
+
+	log.Info().Str("app", appName).Int("actions", len(plan.Actions)).Msg("Cleaning plan created")
+	return plan, nil
+}
+
+// planBackup adds a backup action for path if backups are enabled and the backup policy wants one
+func (e *Engine) planBackup(plan *Plan, phase, cacheDir, path, backupName string) {
+	if !e.config.BackupOptions.Enabled {
+		return
+	}
+	if !e.wantsBackup(phase, cacheDir, path) {
+		log.Info().Str("path", path).Str("phase", phase).Msg("Backup policy skips this path, modifying without backup")
+		return
+	}
+	plan.Add(Action{Phase: phase, Type: ActionBackup, Path: path, BackupName: backupName, Size: e.GetDirectorySize(path)})
+}
+
+// planTelemetry plans new telemetry IDs and removed session keys in the identifier files
+func (e *Engine) planTelemetry(plan *Plan, appPath string) {
+	dbFiles := e.config.CleaningOptions.DatabaseFiles
+
+	// 使用增强的递归文件查找函数
+	log.Info().Str("app", plan.AppName).Str("path", appPath).Strs("target_files", dbFiles).Msg("Starting to find identifier files")
+	foundFiles := e.findFilesRecursiveAdvanced(appPath, dbFiles)
+
+	if len(foundFiles) == 0 {
+		// 如果没有找到配置的文件，尝试查找所有可能的数据库文件
+		log.Warn().Str("app", plan.AppName).Msg("No configured identifier files found, trying to find all possible database files")
+		foundFiles = e.findDatabaseFiles(appPath)
+	}
+
+	for _, filePath := range foundFiles {
+		var actions []Action
+		switch {
+		case isSQLiteFileName(filePath):
+			actions = e.planTelemetrySQLite(filePath)
+		case isJSONFileName(filePath):
+			actions = e.planTelemetryJSON(filePath)
+		default:
+			log.Debug().Str("file", filePath).Msg("Unsupported file type, skipping")
+			continue
+		}
+
+		if len(actions) == 0 {
+			log.Debug().Str("file", filePath).Msg("No identifiers to modify")
+			continue
+		}
+
+		e.planBackup(plan, PhaseTelemetry, "", filePath, fmt.Sprintf("telemetry_%s", filepath.Base(filePath)))
+		plan.Add(actions...)
+	}
+}
+
+// newTelemetryValue 名称包含 session 的键使用会话 ID，其余使用机器 ID
+func newTelemetryValue(key, machineID, sessionID string) string {
+	if strings.Contains(strings.ToLower(key), "session") {
+		return sessionID
+	}
+	return machineID
+}
+
+// planTelemetrySQLite 在键值表中查找遥测键和会话键；每个文件使用一组新的 ID
+func (e *Engine) planTelemetrySQLite(dbPath string) []Action {
+	db, err := openSQLite(dbPath)
+	if err != nil {
+		log.Warn().Str("path", dbPath).Err(err).Msg("Failed to open database")
+		return nil
+	}
+	defer db.Close()
+
+	tables, err := e.findRelevantTables(db)
+	if err != nil {
+		log.Error().Str("path", dbPath).Err(err).Msg("Failed to find relevant tables")
+		return nil
+	}
+
+	newMachineID := uuid.New().String()
+	newSessionID := uuid.New().String()
+
+	var actions []Action
+	for _, table := range tables {
+		countSQL := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s = ?", quoteIdentifier(table.name), quoteIdentifier(table.keyColumn))
+
+		for _, key := range e.config.CleaningOptions.TelemetryKeys {
+			if count := countRows(db, countSQL, key); count > 0 {
+				actions = append(actions, Action{
+					Phase: PhaseTelemetry, Type: ActionUpdateKey, Path: dbPath,
+					Table: table.name, KeyColumn: table.keyColumn, ValueColumn: table.valueColumn,
+					Key: key, Value: newTelemetryValue(key, newMachineID, newSessionID), Count: count,
+				})
+			}
+		}
+
+		for _, key := range e.config.CleaningOptions.SessionKeys {
+			if count := countRows(db, countSQL, key); count > 0 {
+				actions = append(actions, Action{
+					Phase: PhaseTelemetry, Type: ActionDeleteKey, Path: dbPath,
+					Table: table.name, KeyColumn: table.keyColumn, Key: key, Count: count,
+				})
+			}
+		}
+	}
+	return actions
+}
+
+// planTelemetryJSON 统计 JSON 文件中各层级出现的遥测键和会话键
+func (e *Engine) planTelemetryJSON(jsonPath string) []Action {
+	jsonData, err := readJSONObject(jsonPath)
+	if err != nil {
+		log.Warn().Str("path", jsonPath).Err(err).Msg("Failed to read JSON file")
+		return nil
+	}
+	if jsonData == nil {
+		return nil
+	}
+
+	updates := make(map[string]string)
+	for _, key := range e.config.CleaningOptions.TelemetryKeys {
+		updates[key] = ""
+	}
+	deletes := make(map[string]bool)
+	for _, key := range e.config.CleaningOptions.SessionKeys {
+		deletes[key] = true
+	}
+
+	updated := make(map[string]int64)
+	deleted := make(map[string]int64)
+	rewriteJSON(jsonData, updates, deletes, updated, deleted)
+
+	newMachineID := uuid.New().String()
+	newSessionID := uuid.New().String()
+
+	var actions []Action
+	for _, key := range e.config.CleaningOptions.TelemetryKeys {
+		if count := updated[key]; count > 0 {
+			actions = append(actions, Action{
+				Phase: PhaseTelemetry, Type: ActionUpdateKey, Path: jsonPath,
+				Key: key, Value: newTelemetryValue(key, newMachineID, newSessionID), Count: count,
+			})
+		}
+	}
+	for _, key := range e.config.CleaningOptions.SessionKeys {
+		if count := deleted[key]; count > 0 {
+			actions = append(actions, Action{Phase: PhaseTelemetry, Type: ActionDeleteKey, Path: jsonPath, Key: key, Count: count})
+		}
+	}
+	return actions
+}
+
+// planDatabases plans the removal of cached and account related rows from every database file
+func (e *Engine) planDatabases(plan *Plan, appPath string) {
+	log.Info().Str("app", plan.AppName).Str("path", appPath).Msg("开始分析数据库")
+
+	for _, dbPath := range e.findDatabaseFiles(appPath) {
+		// 检查是否是备份文件
+		if strings.Contains(strings.ToLower(dbPath), "backup") || strings.Contains(dbPath, ".bak") {
+			log.Debug().Str("path", dbPath).Msg("跳过备份文件")
+			continue
+		}
+
+		actions := e.planDatabaseSQLite(dbPath)
+		if len(actions) == 0 {
+			continue
+		}
+
+		e.planBackup(plan, PhaseDatabase, "", dbPath, fmt.Sprintf("database_%s", filepath.Base(dbPath)))
+		plan.Add(actions...)
+	}
+}
+
+// planDatabaseSQLite 依次规划：清空缓存表、按关键词删除记录、清空用户相关列
+func (e *Engine) planDatabaseSQLite(dbPath string) []Action {
+	db, err := openSQLite(dbPath)
+	if err != nil {
+		log.Warn().Str("path", dbPath).Err(err).Msg("打开数据库失败")
+		return nil
+	}
+	defer db.Close()
+
+	tableNames, err := userTables(db)
+	if err != nil {
+		log.Error().Str("path", dbPath).Err(err).Msg("获取表列表失败")
+		return nil
+	}
+
+	keywords := e.config.CleaningOptions.DatabaseKeywords
+	var actions []Action
+
+	// 首先重置缓存表（完全删除）
+	cacheTables := make(map[string]bool)
+	for _, tableName := range tableNames {
+		// 检查表名是否安全
+		if !isValidTableName(tableName) {
+			log.Warn().Str("table", tableName).Msg("跳过不安全的表名")
+			continue
+		}
+
+		for _, pattern := range e.config.CleaningOptions.CacheTablePatterns {
+			if strings.Contains(strings.ToLower(tableName), pattern) {
+				cacheTables[tableName] = true
+				if count := countRows(db, fmt.Sprintf("SELECT COUNT(*) FROM %s", quoteIdentifier(tableName))); count > 0 {
+					actions = append(actions, Action{
+						Phase: PhaseDatabase, Type: ActionDeleteRows, Path: dbPath, Table: tableName, Count: count,
+						Estimates: e.estimateTableRows(db, tableName, count),
+					})
+				}
+				break
+			}
+		}
+	}
+
+	// 然后处理其他表，按关键词重置
+	for _, tableName := range tableNames {
+		if !isValidTableName(tableName) || cacheTables[tableName] {
+			continue
+		}
+
+		safeColumns, err := safeTableColumns(db, tableName)
+		if err != nil {
+			log.Warn().Str("table", tableName).Err(err).Msg("获取表列信息失败")
+			continue
+		}
+		if len(safeColumns) == 0 {
+			continue
+		}
+
+		where, args := keywordCondition(safeColumns, keywords)
+		if len(keywords) > 0 {
+			countSQL := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s", quoteIdentifier(tableName), where)
+			if count := countRows(db, countSQL, args...); count > 0 {
+				actions = append(actions, Action{
+					Phase: PhaseDatabase, Type: ActionDeleteRows, Path: dbPath,
+					Table: tableName, Columns: safeColumns, Keywords: keywords, Count: count,
+					Estimates: e.estimateKeywordRows(db, tableName, safeColumns, keywords),
+				})
+			}
+		}
+
+		// 检查通用的用户/账户列，只统计不会被上面删除的行
+		for _, column := range safeColumns {
+			if !isUserColumn(column) {
+				continue
+			}
+
+			countSQL := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE %s IS NOT NULL", quoteIdentifier(tableName), quoteIdentifier(column))
+			countArgs := []interface{}{}
+			if len(keywords) > 0 {
+				countSQL += " AND NOT (" + where + ")"
+				countArgs = args
+			}
+			if count := countRows(db, countSQL, countArgs...); count > 0 {
+				actions = append(actions, Action{Phase: PhaseDatabase, Type: ActionClearColumn, Path: dbPath, Table: tableName, Column: column, Count: count})
+			}
+		}
+	}
+
+	return actions
+}
+
+// planCache plans clearing every directory matching the configured cache directories
+func (e *Engine) planCache(plan *Plan, appPath string) {
+	cacheDirs := e.config.CleaningOptions.CacheDirectories
+
+	e.sendProgress(ProgressUpdate{
+		Type:     "cache",
+		Message:  e.localizeMessage("SearchingCacheDirectories", map[string]interface{}{"Count": len(cacheDirs)}),
+		AppName:  plan.AppName,
+		Phase:    PhaseCache,
+		Progress: 15,
+	})
+
+	for _, dirName := range cacheDirs {
+		e.sendProgress(ProgressUpdate{
+			Type:     "cache",
+			Message:  e.localizeMessage("SearchingCacheDirectory", map[string]interface{}{"DirName": dirName}),
+			AppName:  plan.AppName,
+			Phase:    PhaseCache,
+			Progress: 15,
+		})
+
+		// 查找匹配的目录
+		foundDirs := e.findDirectoriesRecursive(appPath, []string{dirName})
+		if len(foundDirs) == 0 {
+			log.Debug().Str("type", dirName).Msg("No directories found")
+			continue
+		}
+		log.Info().Int("count", len(foundDirs)).Str("dir_type", dirName).Msgf("Found %d %s directories", len(foundDirs), dirName)
+
+		for _, dir := range foundDirs {
+			backupName := fmt.Sprintf("cache_%s", strings.ReplaceAll(filepath.Base(dir), "/", "_"))
+			e.planBackup(plan, PhaseCache, dirName, dir, backupName)
+			plan.Add(Action{Phase: PhaseCache, Type: ActionClearDir, Path: dir, CacheDir: dirName, Size: e.GetDirectorySize(dir)})
+		}
+	}
+}
+
+// validatePlan 检查从文件读取的计划确实属于该应用，且只涉及应用数据目录中的路径
+func (e *Engine) validatePlan(plan *Plan) error {
+	if plan.Version != PlanVersion {
+		return fmt.Errorf("unsupported plan version %d", plan.Version)
+	}
+
+	appPath, exists := e.appDataPaths[plan.AppName]
+	if !exists || appPath == "" {
+		return fmt.Errorf(e.localizeMessage("AppNotFound", map[string]interface{}{"AppName": plan.AppName}))
+	}
+	if filepath.Clean(plan.AppPath) != filepath.Clean(appPath) {
+		return fmt.Errorf("plan was created for %s, but %s data is at %s", plan.AppPath, plan.AppName, appPath)
+	}
+
+	if _, err := resolvePhases(plan.PhaseNames()); err != nil {
+		return err
+	}
+	planPhases := make(map[string]bool)
+	for _, name := range plan.PhaseNames() {
+		planPhases[name] = true
+	}
+
+	for _, action := range plan.Actions {
+		rel, err := filepath.Rel(appPath, action.Path)
+		if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+			return fmt.Errorf("plan action %d targets %s outside of %s", action.ID, action.Path, appPath)
+		}
+
+		if !planPhases[action.Phase] {
+			return fmt.Errorf("plan action %d has unknown phase %q", action.ID, action.Phase)
+		}
+		// 额外阶段的动作类型由阶段自己解释
+		if !isBuiltinPhase(action.Phase) {
+			continue
+		}
+
+		switch action.Type {
+		case ActionBackup, ActionClearDir:
+		case ActionUpdateKey, ActionDeleteKey:
+			if action.Key == "" || (action.Table != "" && action.KeyColumn == "") || (action.Type == ActionUpdateKey && action.Table != "" && action.ValueColumn == "") {
+				return fmt.Errorf("plan action %d is incomplete", action.ID)
+			}
+		case ActionDeleteRows:
+			if action.Table == "" {
+				return fmt.Errorf("plan action %d is incomplete", action.ID)
+			}
+		case ActionClearColumn:
+			if action.Table == "" || action.Column == "" {
+				return fmt.Errorf("plan action %d is incomplete", action.ID)
+			}
+		default:
+			return fmt.Errorf("plan action %d has unknown type %q", action.ID, action.Type)
+		}
+	}
+	return nil
+}
+
+// openSQLite 尝试使用不同的连接参数打开数据库
+func openSQLite(dbPath string) (*sql.DB, error) {
+	connectionStrings := []string{
+		dbPath + "?_journal=WAL&_timeout=5000",
+		dbPath + "?mode=rw",
+		dbPath, // 简单连接，作为最后尝试
+	}
+
+	var lastErr error
+	for _, connStr := range connectionStrings {
+		db, err := sql.Open("sqlite", connStr)
+		if err != nil {
+			log.Debug().Str("connection", connStr).Err(err).Msg("Failed to open database connection")
+			lastErr = err
+			continue
+		}
+
+		// 检查数据库连接
+		if err := db.Ping(); err != nil {
+			log.Debug().Str("connection", connStr).Err(err).Msg("Failed to ping database")
+			db.Close()
+			lastErr = err
+			continue
+		}
+
+		log.Debug().Str("connection", connStr).Msg("Successfully connected to database")
+		return db, nil
+	}
+	return nil, lastErr
+}
+
+// userTables 返回数据库中除系统表外的所有表
+func userTables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type='table'")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tableNames []string
+	for rows.Next() {
+		var tableName string
+		if err := rows.Scan(&tableName); err != nil {
+			continue
+		}
+		if !strings.HasPrefix(tableName, "sqlite_") {
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	return tableNames, rows.Err()
+}
+
+// safeTableColumns 返回表中名称安全的列
+func safeTableColumns(db *sql.DB, tableName string) ([]string, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", quoteIdentifier(tableName)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []string
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notnull, dfltValue, pk interface{}
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dfltValue, &pk); err != nil {
+			continue
+		}
+		if isValidColumnName(name) {
+			columns = append(columns, name)
+		}
+	}
+	return columns, rows.Err()
+}
+
+// countRows 执行 COUNT 查询，出错时按 0 处理
+func countRows(db *sql.DB, query string, args ...interface{}) int64 {
+	var count int64
+	if err := db.QueryRow(query, args...).Scan(&count); err != nil {
+		log.Debug().Str("query", query).Err(err).Msg("Failed to count rows")
+		return 0
+	}
+	return count
+}
+
+// keywordCondition 构造“任一列包含任一关键词”的条件
+func keywordCondition(columns, keywords []string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	for _, keyword := range keywords {
+		for _, column := range columns {
+			conditions = append(conditions, quoteIdentifier(column)+" LIKE ?")
+			args = append(args, "%"+keyword+"%")
+		}
+	}
+	return strings.Join(conditions, " OR "), args
+}
+
+func isUserColumn(column string) bool {
+	columnLower := strings.ToLower(column)
+	for _, userCol := range userColumns {
+		if strings.Contains(columnLower, userCol) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSQLiteFileName(path string) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".vscdb", ".db", ".sqlite", ".sqlite3":
+		return true
+	}
+	return false
+}
+
+func isJSONFileName(path string) bool {
+	return strings.ToLower(filepath.Ext(path)) == ".json"
+}
+
+// readJSONObject 读取 JSON 对象；空文件和数组格式的文件不需要处理，返回 nil
+func readJSONObject(jsonPath string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(jsonPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		log.Warn().Str("path", jsonPath).Msg("JSON文件为空")
+		return nil, nil
+	}
+
+	var jsonData map[string]interface{}
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		var jsonArray []interface{}
+		if json.Unmarshal(data, &jsonArray) == nil {
+			log.Warn().Str("path", jsonPath).Msg("JSON文件是数组格式，不支持处理")
+			return nil, nil
+		}
+		return nil, err
+	}
+	return jsonData, nil
+}
+
+// rewriteJSON 递归处理嵌套的JSON结构：字符串类型的 updates 键被替换为对应的新值，deletes 键被删除。
+// 被删除的键的值不再继续处理；updated 和 deleted 按键统计次数
+func rewriteJSON(data map[string]interface{}, updates map[string]string, deletes map[string]bool, updated, deleted map[string]int64) {
+	for key, value := range updates {
+		if current, exists := data[key]; exists {
+			if _, isString := current.(string); isString {
+				data[key] = value
+				updated[key]++
+			}
+		}
+	}
+
+	for key := range deletes {
+		if _, exists := data[key]; exists {
+			delete(data, key)
+			deleted[key]++
+		}
+	}
+
+	// 递归处理嵌套的对象
+	for _, val := range data {
+		switch nested := val.(type) {
+		case map[string]interface{}:
+			rewriteJSON(nested, updates, deletes, updated, deleted)
+		case []interface{}:
+			for _, item := range nested {
+				if nestedItem, isMap := item.(map[string]interface{}); isMap {
+					rewriteJSON(nestedItem, updates, deletes, updated, deleted)
+				}
+			}
+		}
+	}
+}
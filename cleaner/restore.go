@@ -0,0 +1,221 @@
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"Cursor_Windsurf_Reset/config"
+)
+
+// Restore puts every file and directory backed up in a session back at its original location
+func (e *Engine) Restore(sessionID string) error {
+	manifest, err := e.loadManifest(sessionID)
+	if err != nil {
+		return err
+	}
+
+	if e.IsAppRunning(manifest.AppName) {
+		return fmt.Errorf(e.localizeMessage("AppRunning", map[string]interface{}{"AppName": manifest.AppName}))
+	}
+
+	key, err := e.sessionKey(manifest)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("session", sessionID).Str("app", manifest.AppName).Int("items", len(manifest.Items)).Msg("Restoring backup session")
+
+	items := restorableItems(manifest)
+	var failed []string
+
+	for _, item := range items {
+		if e.dryRun {
+			log.Info().Str("path", item.SourcePath).Str("backup", item.BackupPath).Msg("Would restore item")
+			continue
+		}
+
+		if err := e.restoreItem(manifest.dir, item, key); err != nil {
+			log.Error().Str("path", item.SourcePath).Str("backup", item.BackupPath).Err(err).Msg("Failed to restore item")
+			failed = append(failed, item.SourcePath)
+			continue
+		}
+
+		log.Info().Str("path", item.SourcePath).Str("backup", item.BackupPath).Msg("Restored item")
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore %d of %d items: %s", len(failed), len(items), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// restorableItems returns the backup to restore for every source path of a session.
+// 同一文件可能在多个阶段被备份（例如 state.vscdb），只保留最早的那份未修改的备份
+func restorableItems(manifest *Manifest) []ManifestItem {
+	seen := make(map[string]bool)
+	var items []ManifestItem
+	for _, item := range manifest.Items {
+		if seen[item.SourcePath] {
+			continue
+		}
+		seen[item.SourcePath] = true
+		items = append(items, item)
+	}
+	return items
+}
+
+// restoreItem copies a single backup back to its source path.
+// 备份先恢复到源路径旁边的临时位置并按清单校验，成功后才替换源路径；备份缺失、损坏或无法解密时原有数据保持不变
+func (e *Engine) restoreItem(sessionDir string, item ManifestItem, key []byte) error {
+	format := item.BackupFormat()
+	backupPath := filepath.Join(sessionDir, item.BackupPath)
+	if format != config.BackupFormatStore {
+		if _, err := os.Stat(backupPath); err != nil {
+			return fmt.Errorf("backup not available: %w", err)
+		}
+	}
+
+	if item.Encrypted {
+		// 先解密到会话目录中的临时文件，认证通过后才会动到原始位置
+		decrypted, err := decryptBackup(sessionDir, backupPath, key)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(decrypted)
+		backupPath = decrypted
+	}
+
+	if err := os.MkdirAll(filepath.Dir(item.SourcePath), 0755); err != nil {
+		return err
+	}
+	staged, err := stagingPath(item.SourcePath, item.IsDir)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(staged)
+
+	stagedItem := item
+	stagedItem.SourcePath = staged
+	if err := e.restoreItemContents(backupPath, stagedItem, format); err != nil {
+		return err
+	}
+	if item.SHA256 != "" {
+		sum, err := pathSHA256(staged)
+		if err != nil {
+			return err
+		}
+		if sum != item.SHA256 {
+			return fmt.Errorf("%w: restored content of %s does not match the manifest checksum", ErrBackupVerification, item.SourcePath)
+		}
+	}
+
+	// 按清单恢复原始权限和修改时间
+	applyFileMetadata(staged, item.Mode, item.ModTime)
+
+	if err := replacePath(staged, item.SourcePath); err != nil {
+		return err
+	}
+	if !item.IsDir {
+		removeStaleSQLiteSidecars(item.SourcePath)
+	}
+	return nil
+}
+
+// stagingPath creates an empty file or directory next to target to restore into
+func stagingPath(target string, isDir bool) (string, error) {
+	pattern := "." + filepath.Base(target) + ".restore-*"
+	if isDir {
+		return os.MkdirTemp(filepath.Dir(target), pattern)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), pattern)
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	return tmp.Name(), nil
+}
+
+// replacePath moves staged to target. 目录无法直接覆盖，先将已有的目标移到一旁，替换失败时放回原处
+func replacePath(staged, target string) error {
+	targetInfo, err := os.Lstat(target)
+	if os.IsNotExist(err) {
+		return os.Rename(staged, target)
+	}
+	if err != nil {
+		return err
+	}
+	stagedInfo, err := os.Lstat(staged)
+	if err != nil {
+		return err
+	}
+	if !targetInfo.IsDir() && !stagedInfo.IsDir() {
+		return os.Rename(staged, target)
+	}
+
+	aside := staged + ".old"
+	if err := os.Rename(target, aside); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, target); err != nil {
+		if restoreErr := os.Rename(aside, target); restoreErr != nil {
+			log.Error().Str("path", target).Str("moved_to", aside).Err(restoreErr).Msg("Failed to move original data back")
+		}
+		return err
+	}
+	if err := os.RemoveAll(aside); err != nil {
+		log.Warn().Str("path", aside).Err(err).Msg("Failed to remove replaced data")
+	}
+	return nil
+}
+
+func (e *Engine) restoreItemContents(backupPath string, item ManifestItem, format string) error {
+	if format == config.BackupFormatStore {
+		return e.restoreFromStore(item)
+	}
+	return restoreBackup(backupPath, item.SourcePath, item.IsDir, format)
+}
+
+// decryptBackup decrypts an encrypted backup into a temporary file and returns its path
+func decryptBackup(sessionDir, backupPath string, key []byte) (string, error) {
+	if key == nil {
+		return "", fmt.Errorf("backup is encrypted but the session has no encryption parameters: %s", backupPath)
+	}
+
+	tmp, err := os.CreateTemp(sessionDir, ".decrypt-*")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+
+	if err := decryptFile(key, backupPath, tmp.Name()); err != nil {
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to decrypt %s: %w", filepath.Base(backupPath), err)
+	}
+	return tmp.Name(), nil
+}
+
+// removeStaleSQLiteSidecars 删除被替换数据库遗留的 -wal/-shm 文件，否则它们会被应用到恢复后的数据库上
+func removeStaleSQLiteSidecars(dbPath string) {
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		sidecar := dbPath + suffix
+		if _, err := os.Stat(sidecar); err == nil {
+			if err := os.Remove(sidecar); err != nil {
+				log.Warn().Str("path", sidecar).Err(err).Msg("Failed to remove stale SQLite sidecar file")
+			}
+		}
+	}
+}
+
+// safeJoin joins an archive entry name to root and rejects names that escape it
+func safeJoin(root, name string) (string, error) {
+	destPath := filepath.Join(root, filepath.FromSlash(name))
+	rel, err := filepath.Rel(root, destPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry escapes target directory: %s", name)
+	}
+	return destPath, nil
+}
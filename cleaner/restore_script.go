@@ -0,0 +1,772 @@
+package cleaner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"Cursor_Windsurf_Reset/config"
+)
+
+// 恢复脚本只依赖系统自带工具，没有安装本工具的用户也可以直接在备份目录中运行
+const (
+	restoreScriptSh   = "restore.sh"
+	restoreScriptPs1  = "restore.ps1"
+	restoreScriptBat  = "restore.bat"
+	restoreScriptNote = "Close the application before running this script."
+)
+
+// writeRestoreScripts generates POSIX shell, PowerShell and batch restore scripts in a session directory
+func writeRestoreScripts(sessionDir string, manifest *Manifest) error {
+	items := restorableItems(manifest)
+
+	scripts := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+	}{
+		{restoreScriptSh, buildShellRestoreScript(manifest, items), 0700},
+		{restoreScriptPs1, buildPowerShellRestoreScript(manifest, items), backupFileMode},
+		{restoreScriptBat, buildBatchRestoreScript(sessionDir, manifest, items), backupFileMode},
+	}
+
+	for _, script := range scripts {
+		path := filepath.Join(sessionDir, script.name)
+		if err := os.WriteFile(path, []byte(script.content), script.mode); err != nil {
+			return fmt.Errorf("failed to write restore script %s: %w", script.name, err)
+		}
+	}
+
+	return nil
+}
+
+func restoreScriptHeader(comment string, manifest *Manifest) string {
+	return fmt.Sprintf("%s Restore script generated by Cursor_Windsurf_Reset\n"+
+		"%s Application: %s, session: %s, created: %s\n"+
+		"%s %s\n",
+		comment, comment, manifest.AppName, manifest.SessionID, manifest.CreatedAt.Format(time.RFC3339),
+		comment, restoreScriptNote)
+}
+
+// restoreKind names the restore routine an item needs, e.g. "file", "zip_dir" or "tar_file"
+func restoreKind(item ManifestItem) string {
+	kind := "file"
+	if item.IsDir {
+		kind = "dir"
+	}
+
+	switch item.BackupFormat() {
+	case config.BackupFormatZip:
+		return "zip_" + kind
+	case config.BackupFormatTarGz, config.BackupFormatTarZst:
+		return "tar_" + kind
+	case config.BackupFormatStore:
+		return "store_" + kind
+	}
+	return kind
+}
+
+// storeEntryPath returns the path of a file of a store backup relative to the restored directory
+func storeEntryPath(file StoreFile) string {
+	return filepath.FromSlash(file.Path)
+}
+
+// storeDirsReversed returns the directories of a store backup deepest first, 目录的权限和时间要在写完子项之后设置
+func storeDirsReversed(item ManifestItem) []StoreFile {
+	var dirs []StoreFile
+	for i := len(item.Files) - 1; i >= 0; i-- {
+		if item.Files[i].IsDir {
+			dirs = append(dirs, item.Files[i])
+		}
+	}
+	return dirs
+}
+
+func octalMode(mode os.FileMode) string {
+	return fmt.Sprintf("%04o", mode.Perm())
+}
+
+func shQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func buildShellRestoreScript(manifest *Manifest, items []ManifestItem) string {
+	var b strings.Builder
+
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString(restoreScriptHeader("#", manifest))
+	b.WriteString(`
+set -u
+BACKUP_DIR=$(cd "$(dirname "$0")" && pwd)
+STORE_DIR="$BACKUP_DIR/../../.store/objects"
+FAILED=0
+STAGE=""
+STAGED=""
+
+# 不带参数时计算标准输入的哈希
+sha256_of() {
+	if command -v sha256sum >/dev/null 2>&1; then
+		sha256sum "$@" | awk '{print $1}'
+	else
+		shasum -a 256 "$@" | awk '{print $1}'
+	fi
+}
+
+# 与清单中目录的校验和相同：按相对路径排序，对每个文件和符号链接的 "路径\0哈希\n" 再做一次哈希
+tree_sha256() {
+	(cd "$1" && find . \( -type f -o -type l \) | sed 's|^\./||' | LC_ALL=C sort | while IFS= read -r path; do
+		if [ -L "$path" ]; then
+			sum=$(printf 'symlink\000%s' "$(readlink "$path")" | sha256_of)
+		else
+			sum=$(sha256_of < "$path")
+		fi
+		printf '%s\000%s\n' "$path" "$sum"
+	done) | sha256_of
+}
+
+fail() {
+	echo "failed to restore: $1" >&2
+	FAILED=$((FAILED + 1))
+}
+
+# 每一项先恢复到目标旁边的临时目录 $STAGE 中的 $STAGED，
+# 与清单的 SHA-256 一致后才替换目标；备份缺失或损坏时目标保持不变
+stage_begin() {
+	STAGE=""
+	STAGED=""
+	parent=$(dirname "$1")
+	mkdir -p "$parent" && STAGE=$(mktemp -d "$parent/.$(basename "$1").restore-XXXXXX") && STAGED="$STAGE/$(basename "$1")"
+}
+
+# stage_commit <target> <sha256>
+stage_commit() {
+	if [ -d "$STAGED" ]; then
+		sum=$(tree_sha256 "$STAGED")
+	else
+		sum=$(sha256_of < "$STAGED")
+	fi
+	if [ -n "$2" ] && [ "$sum" != "$2" ]; then
+		echo "checksum mismatch, not overwriting: $1" >&2
+		return 1
+	fi
+
+	if [ ! -d "$STAGED" ]; then
+		mv -f "$STAGED" "$1" && rm -f "$1-wal" "$1-shm" "$1-journal"
+		return
+	fi
+	if [ ! -e "$1" ] && [ ! -L "$1" ]; then
+		mv "$STAGED" "$1"
+		return
+	fi
+	# 目录无法直接覆盖，先把原目录移进临时目录，替换失败时放回原处
+	mv "$1" "$STAGE/.old" || return 1
+	if ! mv "$STAGED" "$1"; then
+		mv "$STAGE/.old" "$1"
+		return 1
+	fi
+}
+
+# stage_end <target> <status>: 删除临时目录并汇报结果
+stage_end() {
+	if [ -n "$STAGE" ]; then
+		rm -rf "$STAGE"
+	fi
+	if [ "$2" -eq 0 ]; then
+		echo "restored: $1"
+	else
+		fail "$1"
+	fi
+}
+
+# restore_<kind> <backup> <target> <sha256>
+restore_file() {
+	stage_begin "$2" && cp -p "$BACKUP_DIR/$1" "$STAGED" && stage_commit "$2" "$3"
+	stage_end "$2" $?
+}
+
+restore_dir() {
+	stage_begin "$2" && cp -Rp "$BACKUP_DIR/$1" "$STAGED" && stage_commit "$2" "$3"
+	stage_end "$2" $?
+}
+
+restore_zip_file() {
+	stage_begin "$2" && unzip -q "$BACKUP_DIR/$1" -d "$STAGE" && stage_commit "$2" "$3"
+	stage_end "$2" $?
+}
+
+restore_zip_dir() {
+	stage_begin "$2" && mkdir "$STAGED" && unzip -q "$BACKUP_DIR/$1" -d "$STAGED" && stage_commit "$2" "$3"
+	stage_end "$2" $?
+}
+
+# tar 会根据文件内容自动识别 gzip/zstd 压缩，-p 保留权限
+restore_tar_file() {
+	stage_begin "$2" && tar -xpf "$BACKUP_DIR/$1" -C "$STAGE" && stage_commit "$2" "$3"
+	stage_end "$2" $?
+}
+
+restore_tar_dir() {
+	stage_begin "$2" && mkdir "$STAGED" && tar -xpf "$BACKUP_DIR/$1" -C "$STAGED" && stage_commit "$2" "$3"
+	stage_end "$2" $?
+}
+
+# 去重存储中的对象按 SHA-256 的前两位分目录保存
+# store_put <sha256> <path> <mode> <mtime>
+store_put() {
+	object="$STORE_DIR/$(printf '%s' "$1" | cut -c1-2)/$1"
+	if [ "$(sha256_of "$object")" != "$1" ]; then
+		echo "checksum mismatch: $object" >&2
+		return 1
+	fi
+	mkdir -p "$(dirname "$2")" && cp "$object" "$2" && chmod "$3" "$2" && touch -t "$4" "$2"
+}
+
+# restore_store_file <object sha256> <target> <mode> <mtime> <sha256>
+restore_store_file() {
+	stage_begin "$2" && store_put "$1" "$STAGED" "$3" "$4" && stage_commit "$2" "$5"
+	stage_end "$2" $?
+}
+
+# store_begin <target> <sha256>，之后的 store_* 都写入 $STAGED
+store_begin() {
+	ITEM_FAILED=0
+	ITEM_SUM="$2"
+	stage_begin "$1" && mkdir "$STAGED" || ITEM_FAILED=1
+}
+
+# 临时目录创建失败后跳过剩余的条目，$STAGED 此时不可用
+store_ok() {
+	[ "$ITEM_FAILED" -eq 0 ]
+}
+
+store_mkdir() {
+	store_ok && mkdir -p "$1" || ITEM_FAILED=1
+}
+
+store_file() {
+	store_ok && store_put "$@" || ITEM_FAILED=1
+}
+
+store_link() {
+	store_ok && ln -s "$1" "$2" || ITEM_FAILED=1
+}
+
+store_meta() {
+	store_ok && chmod "$2" "$1" && touch -t "$3" "$1" || ITEM_FAILED=1
+}
+
+store_end() {
+	store_ok && stage_commit "$1" "$ITEM_SUM"
+	stage_end "$1" $?
+}
+
+`)
+
+	for _, item := range items {
+		kind := restoreKind(item)
+		backup, target := shQuote(item.BackupPath), shQuote(item.SourcePath)
+		switch kind {
+		case "store_file":
+			if len(item.Files) == 1 {
+				fmt.Fprintf(&b, "restore_store_file %s %s %s %s %s\n", item.Files[0].SHA256, target, octalMode(item.Mode), shTouchTime(item.ModTime), shQuote(item.SHA256))
+			}
+		case "store_dir":
+			writeShellStoreDir(&b, item)
+		default:
+			fmt.Fprintf(&b, "restore_%s %s %s %s\n", kind, backup, target, shQuote(item.SHA256))
+		}
+	}
+
+	b.WriteString(`
+if [ "$FAILED" -gt 0 ]; then
+	echo "$FAILED item(s) could not be restored" >&2
+	exit 1
+fi
+echo "Restore complete."
+`)
+
+	return b.String()
+}
+
+// shTouchTime formats t for touch -t, which GNU and BSD touch both accept
+func shTouchTime(t time.Time) string {
+	return t.Local().Format("200601021504.05")
+}
+
+func writeShellStoreDir(b *strings.Builder, item ManifestItem) {
+	target := shQuote(item.SourcePath)
+	fmt.Fprintf(b, "store_begin %s %s\n", target, shQuote(item.SHA256))
+	for _, file := range item.Files {
+		path := `"$STAGED"/` + shQuote(storeEntryPath(file))
+		switch {
+		case file.IsDir:
+			fmt.Fprintf(b, "store_mkdir %s\n", path)
+		case isSymlink(file.Mode):
+			fmt.Fprintf(b, "store_link %s %s\n", shQuote(file.Link), path)
+		default:
+			fmt.Fprintf(b, "store_file %s %s %s %s\n", file.SHA256, path, octalMode(file.Mode), shTouchTime(file.ModTime))
+		}
+	}
+	for _, dir := range storeDirsReversed(item) {
+		fmt.Fprintf(b, "store_meta \"$STAGED\"/%s %s %s\n", shQuote(storeEntryPath(dir)), octalMode(dir.Mode), shTouchTime(dir.ModTime))
+	}
+	fmt.Fprintf(b, "store_meta \"$STAGED\" %s %s\n", octalMode(item.Mode), shTouchTime(item.ModTime))
+	fmt.Fprintf(b, "store_end %s\n", target)
+}
+
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func buildPowerShellRestoreScript(manifest *Manifest, items []ManifestItem) string {
+	var b strings.Builder
+
+	b.WriteString(restoreScriptHeader("#", manifest))
+	b.WriteString(`
+$ErrorActionPreference = 'Stop'
+$BackupDir = $PSScriptRoot
+$StoreDir = Join-Path $BackupDir '..\..\.store\objects'
+$Failed = 0
+
+function Remove-Sidecars($Target) {
+    foreach ($suffix in '-wal', '-shm', '-journal') {
+        Remove-Item -LiteralPath ($Target + $suffix) -Force -ErrorAction SilentlyContinue
+    }
+}
+
+function Get-TextHash($Text) {
+    $sha256 = [Security.Cryptography.SHA256]::Create()
+    $hash = $sha256.ComputeHash([Text.Encoding]::UTF8.GetBytes($Text))
+    ([BitConverter]::ToString($hash) -replace '-', '').ToLower()
+}
+
+# 与清单中目录的校验和相同：按相对路径排序，对每个文件和符号链接的 "路径\0哈希\n" 再做一次哈希
+function Get-TreeHash($Root) {
+    $prefix = (Get-Item -LiteralPath $Root -Force).FullName.TrimEnd('\') + '\'
+    $sums = New-Object 'System.Collections.Generic.Dictionary[string,string]' ([StringComparer]::Ordinal)
+    foreach ($entry in Get-ChildItem -LiteralPath $Root -Recurse -Force) {
+        $isLink = [bool]($entry.Attributes -band [IO.FileAttributes]::ReparsePoint)
+        if ($entry.PSIsContainer -and -not $isLink) {
+            continue
+        }
+        $rel = $entry.FullName.Substring($prefix.Length).Replace('\', '/')
+        if ($isLink) {
+            $sums[$rel] = Get-TextHash ('symlink' + [char]0 + ($entry.Target -join ''))
+        } else {
+            $sums[$rel] = (Get-FileHash -Algorithm SHA256 -LiteralPath $entry.FullName).Hash.ToLower()
+        }
+    }
+    $paths = [string[]]@($sums.Keys)
+    [Array]::Sort($paths, [StringComparer]::Ordinal)
+    Get-TextHash (($paths | ForEach-Object { $_ + [char]0 + $sums[$_] + [char]10 }) -join '')
+}
+
+# 每一项先由 $Fill 恢复到目标旁边临时目录中的 $Staged，
+# 与清单的 SHA-256 一致后才替换目标；备份缺失或损坏时目标保持不变
+function Restore-Item($Target, $Sum, [scriptblock]$Fill) {
+    $stage = $null
+    try {
+        $parent = Split-Path -Parent $Target
+        $name = Split-Path -Leaf $Target
+        New-Item -ItemType Directory -Force -Path $parent | Out-Null
+        $stage = Join-Path $parent ('.' + $name + '.restore-' + [Guid]::NewGuid().ToString('N').Substring(0, 8))
+        New-Item -ItemType Directory -Path $stage | Out-Null
+        $Staged = Join-Path $stage $name
+        & $Fill
+
+        $isDir = Test-Path -LiteralPath $Staged -PathType Container
+        if ($isDir) {
+            $actual = Get-TreeHash $Staged
+        } else {
+            $actual = (Get-FileHash -Algorithm SHA256 -LiteralPath $Staged).Hash.ToLower()
+        }
+        if ($Sum -and $actual -ne $Sum) {
+            throw "checksum mismatch, not overwriting"
+        }
+
+        # 原有数据先移进临时目录，替换失败时放回原处
+        $old = Join-Path $stage '.old'
+        $hadTarget = Test-Path -LiteralPath $Target
+        if ($hadTarget) {
+            Move-Item -LiteralPath $Target -Destination $old
+        }
+        try {
+            Move-Item -LiteralPath $Staged -Destination $Target
+        } catch {
+            if ($hadTarget) {
+                Move-Item -LiteralPath $old -Destination $Target
+            }
+            throw
+        }
+        if (-not $isDir) {
+            Remove-Sidecars $Target
+        }
+        Write-Host "restored: $Target"
+    } catch {
+        Write-Warning "failed to restore ${Target}: $_"
+        $script:Failed++
+    } finally {
+        if ($stage) {
+            Remove-Item -LiteralPath $stage -Recurse -Force -ErrorAction SilentlyContinue
+        }
+    }
+}
+
+function Restore-File($Backup, $Target, $Sum) {
+    Restore-Item $Target $Sum { Copy-Item -LiteralPath (Join-Path $BackupDir $Backup) -Destination $Staged }
+}
+
+function Restore-Dir($Backup, $Target, $Sum) {
+    Restore-Item $Target $Sum { Copy-Item -LiteralPath (Join-Path $BackupDir $Backup) -Destination $Staged -Recurse }
+}
+
+function Restore-ZipFile($Backup, $Target, $Sum) {
+    Restore-Item $Target $Sum { Expand-Archive -LiteralPath (Join-Path $BackupDir $Backup) -DestinationPath $stage }
+}
+
+function Restore-ZipDir($Backup, $Target, $Sum) {
+    Restore-Item $Target $Sum { Expand-Archive -LiteralPath (Join-Path $BackupDir $Backup) -DestinationPath $Staged }
+}
+
+function Expand-Tar($Archive, $Destination) {
+    tar.exe -xpf $Archive -C $Destination
+    if ($LASTEXITCODE -ne 0) {
+        throw "tar exited with code $LASTEXITCODE"
+    }
+}
+
+function Restore-TarFile($Backup, $Target, $Sum) {
+    Restore-Item $Target $Sum { Expand-Tar (Join-Path $BackupDir $Backup) $stage }
+}
+
+function Restore-TarDir($Backup, $Target, $Sum) {
+    Restore-Item $Target $Sum {
+        New-Item -ItemType Directory -Path $Staged | Out-Null
+        Expand-Tar (Join-Path $BackupDir $Backup) $Staged
+    }
+}
+
+function Set-MTime($Path, $Mtime) {
+    (Get-Item -LiteralPath $Path -Force).LastWriteTimeUtc = [DateTimeOffset]::Parse($Mtime).UtcDateTime
+}
+
+function Copy-StoreObject($Sum, $Path, $Mtime) {
+    $src = Join-Path (Join-Path $StoreDir $Sum.Substring(0, 2)) $Sum
+    if ((Get-FileHash -Algorithm SHA256 -LiteralPath $src).Hash.ToLower() -ne $Sum) {
+        throw "checksum mismatch: $src"
+    }
+    New-Item -ItemType Directory -Force -Path (Split-Path -Parent $Path) | Out-Null
+    Copy-Item -LiteralPath $src -Destination $Path -Force
+    Set-MTime $Path $Mtime
+}
+
+function Restore-StoreFile($Object, $Target, $Mtime, $Sum) {
+    Restore-Item $Target $Sum { Copy-StoreObject $Object $Staged $Mtime }
+}
+
+# $Entries 把目录内容写入 $Staged
+function Restore-StoreDir($Target, $Sum, [scriptblock]$Entries) {
+    Restore-Item $Target $Sum {
+        New-Item -ItemType Directory -Path $Staged | Out-Null
+        & $Entries
+    }
+}
+
+`)
+
+	functions := map[string]string{
+		"file":     "Restore-File",
+		"dir":      "Restore-Dir",
+		"zip_file": "Restore-ZipFile",
+		"zip_dir":  "Restore-ZipDir",
+		"tar_file": "Restore-TarFile",
+		"tar_dir":  "Restore-TarDir",
+	}
+
+	for _, item := range items {
+		kind := restoreKind(item)
+		backup, target := psQuote(item.BackupPath), psQuote(item.SourcePath)
+		switch kind {
+		case "store_file":
+			if len(item.Files) == 1 {
+				fmt.Fprintf(&b, "Restore-StoreFile %s %s %s %s\n", psQuote(item.Files[0].SHA256), target, psQuote(psTime(item.ModTime)), psQuote(item.SHA256))
+			}
+		case "store_dir":
+			writePowerShellStoreDir(&b, item)
+		default:
+			fmt.Fprintf(&b, "%s %s %s %s\n", functions[kind], backup, target, psQuote(item.SHA256))
+		}
+	}
+
+	b.WriteString(`
+if ($Failed -gt 0) {
+    Write-Warning "$Failed item(s) could not be restored"
+    exit 1
+}
+Write-Host "Restore complete."
+`)
+
+	return b.String()
+}
+
+func psTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func writePowerShellStoreDir(b *strings.Builder, item ManifestItem) {
+	fmt.Fprintf(b, "Restore-StoreDir %s %s {\n", psQuote(item.SourcePath), psQuote(item.SHA256))
+	for _, file := range item.Files {
+		path := fmt.Sprintf("(Join-Path $Staged %s)", psQuote(storeEntryPath(file)))
+		switch {
+		case file.IsDir:
+			fmt.Fprintf(b, "    New-Item -ItemType Directory -Force -Path %s | Out-Null\n", path)
+		case isSymlink(file.Mode):
+			fmt.Fprintf(b, "    New-Item -ItemType SymbolicLink -Path %s -Target %s | Out-Null\n", path, psQuote(file.Link))
+		default:
+			fmt.Fprintf(b, "    Copy-StoreObject %s %s %s\n", psQuote(file.SHA256), path, psQuote(psTime(file.ModTime)))
+		}
+	}
+	for _, dir := range storeDirsReversed(item) {
+		fmt.Fprintf(b, "    Set-MTime (Join-Path $Staged %s) %s\n", psQuote(storeEntryPath(dir)), psQuote(psTime(dir.ModTime)))
+	}
+	fmt.Fprintf(b, "    Set-MTime $Staged %s\n", psQuote(psTime(item.ModTime)))
+	b.WriteString("}\n")
+}
+
+// batEscape 批处理中 set "VAR=..." 的值只需要转义百分号
+func batEscape(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// batStoreObject returns the path of a store object relative to the session directory
+func batStoreObject(sum string) string {
+	return fmt.Sprintf(`..\..\%s\objects\%s\%s`, backupStoreDirName, sum[:2], sum)
+}
+
+func writeBatchStoreDir(b *strings.Builder, item ManifestItem) {
+	fmt.Fprintf(b, "set \"SRC=%s\"\n", batEscape(item.SourcePath))
+	b.WriteString("call :store_begin\n")
+	for _, file := range item.Files {
+		fmt.Fprintf(b, "set \"DST=%%STAGED%%\\%s\"\n", batEscape(storeEntryPath(file)))
+		switch {
+		case file.IsDir:
+			b.WriteString("call :store_mkdir\n")
+		case isSymlink(file.Mode):
+			fmt.Fprintf(b, "set \"LNK=%s\"\n", batEscape(file.Link))
+			b.WriteString("call :store_link\n")
+		default:
+			fmt.Fprintf(b, "set \"BAK=%s\"\n", batStoreObject(file.SHA256))
+			fmt.Fprintf(b, "set \"SUM=%s\"\n", file.SHA256)
+			b.WriteString("call :store_file\n")
+		}
+	}
+	b.WriteString("call :stage_commit\n\n")
+}
+
+// backupEntries lists the files of a directory backup the same way treeSHA256 does for its source
+func backupEntries(sessionDir string, item ManifestItem) ([]treeEntry, error) {
+	backupPath := filepath.Join(sessionDir, item.BackupPath)
+	switch format := item.BackupFormat(); format {
+	case config.BackupFormatZip:
+		return zipEntries(backupPath)
+	case config.BackupFormatTarGz, config.BackupFormatTarZst:
+		return tarEntries(backupPath, format)
+	}
+	return treeEntries(backupPath)
+}
+
+// writeBatchVerifyDir 批处理无法计算目录的 treeSHA256，改为逐个校验清单中的文件，并检查没有多出的文件。
+// 文件列表在生成脚本时从备份中读出，并且必须与清单的校验和一致
+func writeBatchVerifyDir(b *strings.Builder, sessionDir string, item ManifestItem) {
+	entries, err := backupEntries(sessionDir, item)
+	if err == nil && item.SHA256 != "" && hashTreeEntries(entries) != item.SHA256 {
+		err = fmt.Errorf("%w: checksum mismatch for %s", ErrBackupVerification, item.BackupPath)
+	}
+	if err != nil {
+		log.Warn().Str("path", item.SourcePath).Err(err).Msg("Backup cannot be verified, the batch restore script will skip it")
+		b.WriteString("set ITEM_FAILED=1\n")
+		return
+	}
+
+	files := 0
+	for _, entry := range entries {
+		// 符号链接在 Windows 上需要额外权限才能解压，不参与校验
+		if entry.link {
+			continue
+		}
+		files++
+		fmt.Fprintf(b, "set \"REL=%s\"\n", batEscape(filepath.FromSlash(entry.relPath)))
+		fmt.Fprintf(b, "set \"SUM=%s\"\n", entry.sum)
+		b.WriteString("call :verify_entry\n")
+	}
+	fmt.Fprintf(b, "set COUNT=%d\n", files)
+	b.WriteString("call :verify_count\n")
+}
+
+func buildBatchRestoreScript(sessionDir string, manifest *Manifest, items []ManifestItem) string {
+	var b strings.Builder
+
+	b.WriteString("@echo off\n")
+	b.WriteString(restoreScriptHeader("rem", manifest))
+	b.WriteString(`setlocal
+set "BACKUP_DIR=%~dp0"
+set FAILED=0
+
+`)
+
+	for _, item := range items {
+		switch kind := restoreKind(item); kind {
+		case "store_file":
+			// 去重存储中的单个文件与普通文件备份的恢复方式相同，只是备份位于存储目录中
+			if len(item.Files) == 1 {
+				fmt.Fprintf(&b, "set \"BAK=%s\"\n", batStoreObject(item.Files[0].SHA256))
+				fmt.Fprintf(&b, "set \"SRC=%s\"\n", batEscape(item.SourcePath))
+				fmt.Fprintf(&b, "set \"SUM=%s\"\n", item.Files[0].SHA256)
+				b.WriteString("call :restore_file\n\n")
+			}
+		case "store_dir":
+			writeBatchStoreDir(&b, item)
+		default:
+			fmt.Fprintf(&b, "set \"BAK=%s\"\n", batEscape(item.BackupPath))
+			fmt.Fprintf(&b, "set \"SRC=%s\"\n", batEscape(item.SourcePath))
+			fmt.Fprintf(&b, "set \"SUM=%s\"\n", item.SHA256)
+			// Windows 自带的 tar (bsdtar) 同时支持 zip 和 tar 归档
+			kind = strings.NewReplacer("zip_", "archive_", "tar_", "archive_").Replace(kind)
+			fmt.Fprintf(&b, "call :restore_%s\n", kind)
+			if item.IsDir {
+				writeBatchVerifyDir(&b, sessionDir, item)
+				b.WriteString("call :stage_commit\n")
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	b.WriteString(`if %FAILED% gtr 0 goto :failed
+echo Restore complete.
+exit /b 0
+
+:failed
+echo %FAILED% item(s) could not be restored
+exit /b 1
+
+:fail
+echo failed to restore: "%SRC%"
+set /a FAILED+=1
+goto :eof
+
+:sidecars
+del /f /q "%SRC%-wal" "%SRC%-shm" "%SRC%-journal" 2>nul
+goto :eof
+
+rem 每一项先恢复到目标旁边临时目录 STAGE 中的 STAGED，
+rem 校验通过后才由 :stage_commit 替换目标；备份缺失或损坏时目标保持不变
+:stage_begin
+set ITEM_FAILED=0
+for %%d in ("%SRC%") do (
+	set "PARENT=%%~dpd"
+	set "NAME=%%~nxd"
+)
+if not exist "%PARENT%" mkdir "%PARENT%"
+set "STAGE=%PARENT%.%NAME%.restore-%RANDOM%%RANDOM%"
+set "STAGED=%STAGE%\%NAME%"
+mkdir "%STAGE%" || set ITEM_FAILED=1
+goto :eof
+
+:stage_commit
+if %ITEM_FAILED% gtr 0 goto :stage_end
+if exist "%SRC%" move /y "%SRC%" "%STAGE%\.old" >nul || set ITEM_FAILED=1
+if %ITEM_FAILED% gtr 0 goto :stage_end
+move /y "%STAGED%" "%SRC%" >nul && goto :stage_end
+set ITEM_FAILED=1
+if exist "%STAGE%\.old" move /y "%STAGE%\.old" "%SRC%" >nul
+:stage_end
+if exist "%STAGE%" rmdir /s /q "%STAGE%"
+if %ITEM_FAILED% gtr 0 goto :fail
+echo restored: "%SRC%"
+goto :eof
+
+rem :check_hash 比较 HASH_PATH 的 SHA-256 与 SUM
+:check_hash
+if %ITEM_FAILED% gtr 0 goto :eof
+if "%SUM%"=="" goto :eof
+set "HASH="
+for /f "delims=" %%h in ('certutil -hashfile "%HASH_PATH%" SHA256 ^| findstr /v /c:":"') do if not defined HASH set "HASH=%%h"
+if defined HASH set "HASH=%HASH: =%"
+if /i "%HASH%"=="%SUM%" goto :eof
+echo checksum mismatch, not overwriting: "%SRC%"
+set ITEM_FAILED=1
+goto :eof
+
+:verify_entry
+set "HASH_PATH=%STAGED%\%REL%"
+call :check_hash
+goto :eof
+
+:verify_count
+if %ITEM_FAILED% gtr 0 goto :eof
+set FOUND=0
+for /f %%c in ('dir /s /b /a:-d-l "%STAGED%" 2^>nul ^| find /c /v ""') do set FOUND=%%c
+if "%FOUND%"=="%COUNT%" goto :eof
+echo unexpected files in backup, not overwriting: "%SRC%"
+set ITEM_FAILED=1
+goto :eof
+
+:restore_file
+call :stage_begin
+if %ITEM_FAILED% equ 0 copy /y "%BACKUP_DIR%%BAK%" "%STAGED%" >nul || set ITEM_FAILED=1
+set "HASH_PATH=%STAGED%"
+call :check_hash
+call :stage_commit
+if %ITEM_FAILED% equ 0 call :sidecars
+goto :eof
+
+rem 目录的校验和替换由调用处的 :verify_entry、:verify_count 和 :stage_commit 完成
+:restore_dir
+call :stage_begin
+if %ITEM_FAILED% equ 0 xcopy "%BACKUP_DIR%%BAK%" "%STAGED%\" /e /h /k /y /q >nul || set ITEM_FAILED=1
+goto :eof
+
+:restore_archive_file
+call :stage_begin
+if %ITEM_FAILED% equ 0 tar -xf "%BACKUP_DIR%%BAK%" -C "%STAGE%" || set ITEM_FAILED=1
+set "HASH_PATH=%STAGED%"
+call :check_hash
+call :stage_commit
+if %ITEM_FAILED% equ 0 call :sidecars
+goto :eof
+
+:restore_archive_dir
+call :stage_begin
+if %ITEM_FAILED% equ 0 mkdir "%STAGED%" || set ITEM_FAILED=1
+if %ITEM_FAILED% equ 0 tar -xf "%BACKUP_DIR%%BAK%" -C "%STAGED%" || set ITEM_FAILED=1
+goto :eof
+
+:store_begin
+call :stage_begin
+if %ITEM_FAILED% equ 0 mkdir "%STAGED%" || set ITEM_FAILED=1
+goto :eof
+
+:store_mkdir
+if %ITEM_FAILED% gtr 0 goto :eof
+if not exist "%DST%" mkdir "%DST%" || set ITEM_FAILED=1
+goto :eof
+
+:store_file
+if %ITEM_FAILED% gtr 0 goto :eof
+for %%d in ("%DST%") do if not exist "%%~dpd" mkdir "%%~dpd"
+copy /y "%BACKUP_DIR%%BAK%" "%DST%" >nul || set ITEM_FAILED=1
+set "HASH_PATH=%DST%"
+call :check_hash
+goto :eof
+
+:store_link
+if %ITEM_FAILED% gtr 0 goto :eof
+mklink "%DST%" "%LNK%" >nul || set ITEM_FAILED=1
+goto :eof
+`)
+
+	// 批处理文件使用 CRLF 换行，否则部分 Windows 版本会错误解析标签
+	return strings.ReplaceAll(b.String(), "\n", "\r\n")
+}
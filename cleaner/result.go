@@ -0,0 +1,159 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Causes of item failures in a PhaseResult, matched with errors.Is
+var (
+	ErrFileMissing  = errors.New("file no longer exists")
+	ErrBackupFailed = errors.New("backup failed")
+	ErrModifyFailed = errors.New("modification failed")
+	ErrClearFailed  = errors.New("directory could not be cleared")
+)
+
+// CleanResult is what ApplyPlan and CleanApplication did, phase by phase.
+// dry-run 时各项数字是实际运行将会产生的结果
+type CleanResult struct {
+	AppName   string        `json:"app_name"`
+	DryRun    bool          `json:"dry_run"`
+	SessionID string        `json:"session_id,omitempty"` // backup session of the run, empty if nothing was backed up
+	Phases    []PhaseResult `json:"phases"`
+}
+
+// PhaseResult counts the work of one phase. 内置阶段只填写与其相关的字段
+type PhaseResult struct {
+	Name           string          `json:"name"`
+	FilesProcessed int             `json:"files_processed"`
+	KeysUpdated    int             `json:"keys_updated"`
+	KeysDeleted    int             `json:"keys_deleted"`
+	RowsRemoved    int             `json:"rows_removed"`
+	ValuesCleared  int             `json:"values_cleared"`
+	DirsCleared    int             `json:"dirs_cleared"`
+	BytesFreed     int64           `json:"bytes_freed"`
+	CacheDirs      []CacheDirStats `json:"cache_dirs,omitempty"`
+	Failures       []Failure       `json:"failures,omitempty"`
+	Err            error           `json:"-"` // error returned by the phase itself
+}
+
+// CacheDirStats are the statistics of one entry of cleaning_options.cache_directories
+type CacheDirStats struct {
+	Name string `json:"name"`
+	CacheStats
+}
+
+// Failure is a file or directory a phase could not process; Err wraps one of the ErrFileMissing etc. causes
+type Failure struct {
+	Path string `json:"path"`
+	Err  error  `json:"-"`
+}
+
+// Phase returns the result of the named phase, or nil if it did not run
+func (r *CleanResult) Phase(name string) *PhaseResult {
+	for i := range r.Phases {
+		if r.Phases[i].Name == name {
+			return &r.Phases[i]
+		}
+	}
+	return nil
+}
+
+// MarshalJSON adds Err as "error"
+func (p PhaseResult) MarshalJSON() ([]byte, error) {
+	type phaseResult PhaseResult
+	return json.Marshal(struct {
+		phaseResult
+		Error string `json:"error,omitempty"`
+	}{phaseResult(p), errorString(p.Err)})
+}
+
+// MarshalJSON adds Err as "error" and its cause as "reason": file_missing, backup_failed, modify_failed or clear_failed
+func (f Failure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Path   string `json:"path"`
+		Reason string `json:"reason,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}{f.Path, failureReason(f.Err), errorString(f.Err)})
+}
+
+// failureReason 返回失败原因的固定名称，供脚本判断
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrFileMissing):
+		return "file_missing"
+	case errors.Is(err, ErrBackupFailed):
+		return "backup_failed"
+	case errors.Is(err, ErrModifyFailed):
+		return "modify_failed"
+	case errors.Is(err, ErrClearFailed):
+		return "clear_failed"
+	}
+	return ""
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Failed reports whether the phase returned an error or could not process some of its items
+func (p *PhaseResult) Failed() bool {
+	return p.Err != nil || len(p.Failures) > 0
+}
+
+// Err combines the errors of all failed phases into one PhaseError each, or returns nil if every phase succeeded
+func (r *CleanResult) Err() error {
+	var errs []error
+	for _, phase := range r.Phases {
+		if phase.Failed() {
+			errs = append(errs, &PhaseError{Phase: phase.Name, Err: phase.Err, Failures: phase.Failures})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// PhaseError is a phase that returned an error or could not process some of its items
+type PhaseError struct {
+	Phase    string
+	Err      error
+	Failures []Failure
+}
+
+func (e *PhaseError) Error() string {
+	var parts []string
+	if e.Err != nil {
+		parts = append(parts, e.Err.Error())
+	}
+	for _, failure := range e.Failures {
+		parts = append(parts, fmt.Sprintf("%s: %v", failure.Path, failure.Err))
+	}
+	return fmt.Sprintf("%s phase failed: %s", e.Phase, strings.Join(parts, "; "))
+}
+
+// Unwrap lets errors.Is find both the phase error and the causes of the item failures
+func (e *PhaseError) Unwrap() []error {
+	var errs []error
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	for _, failure := range e.Failures {
+		errs = append(errs, failure.Err)
+	}
+	return errs
+}
+
+// recordFailure adds a failed item to the running phase; cause 为 ErrFileMissing 等原因之一，err 为具体错误，可为 nil
+func (e *Engine) recordFailure(path string, cause, err error) {
+	if err != nil {
+		cause = fmt.Errorf("%w: %v", cause, err)
+	}
+	log.Debug().Str("path", path).Err(cause).Msg("Item failed")
+	if e.current != nil {
+		e.current.Failures = append(e.current.Failures, Failure{Path: path, Err: cause})
+	}
+}
@@ -0,0 +1,106 @@
+package cleaner
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// RollbackError is returned by ApplyPlan in transactional mode when a run failed or was cancelled.
+// 返回前本次运行修改过的文件和目录已用本次的备份恢复；Cause 是失败或取消的原因
+type RollbackError struct {
+	AppName  string
+	Cause    error
+	Restored []string // files and directories put back from the run's backups
+	Failed   []string // changed paths whose backup could not be restored, see the log
+	NoBackup []string // changed paths without a backup (backups disabled or skipped by the backup policy), left as they are
+}
+
+func (e *RollbackError) Error() string {
+	return fmt.Sprintf("reset of %s rolled back (%d restored, %d not restored): %v", e.AppName, len(e.Restored), len(e.Failed)+len(e.NoBackup), e.Cause)
+}
+
+func (e *RollbackError) Unwrap() error {
+	return e.Cause
+}
+
+// markTouched records that the running reset is about to modify path
+func (e *Engine) markTouched(path string) {
+	for _, touched := range e.touched {
+		if touched == path {
+			return
+		}
+	}
+	e.touched = append(e.touched, path)
+}
+
+// warnUnprotectedChanges 事务模式下提示哪些修改没有备份，失败时无法撤销
+func (e *Engine) warnUnprotectedChanges(plan *Plan) {
+	backedUp := make(map[string]bool)
+	for _, action := range plan.Actions {
+		if action.Type == ActionBackup {
+			backedUp[action.Phase+"\x00"+action.Path] = true
+		}
+	}
+	warned := make(map[string]bool)
+	for _, action := range plan.Actions {
+		if action.Type != ActionBackup && !backedUp[action.Phase+"\x00"+action.Path] && !warned[action.Path] {
+			warned[action.Path] = true
+			log.Warn().Str("path", action.Path).Str("phase", action.Phase).Msg("No backup planned, this change cannot be rolled back")
+		}
+	}
+}
+
+// rollback puts every path the running reset touched back from the session's backups, newest change first
+func (e *Engine) rollback(appName string, cause error) error {
+	result := &RollbackError{AppName: appName, Cause: cause}
+
+	// 同一路径在多个阶段被备份时恢复最早的那份，即运行前的内容
+	items := make(map[string]ManifestItem)
+	if e.session != nil {
+		for _, item := range restorableItems(e.session.manifest) {
+			items[item.SourcePath] = item
+		}
+	}
+
+	log.Warn().Str("app", appName).Err(cause).Int("paths", len(e.touched)).Msg("Reset failed, rolling back")
+	e.sendProgress(ProgressUpdate{
+		Type:    "rollback",
+		Message: e.localizeMessage("RollingBack", map[string]interface{}{"AppName": appName, "Count": len(e.touched)}),
+		AppName: appName,
+	})
+
+	for i := len(e.touched) - 1; i >= 0; i-- {
+		path := e.touched[i]
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			absPath = path
+		}
+
+		item, exists := items[absPath]
+		if !exists {
+			log.Warn().Str("path", path).Msg("No backup of this path in the session, cannot roll back")
+			result.NoBackup = append(result.NoBackup, path)
+			continue
+		}
+
+		if err := e.restoreItem(e.session.dir, item, e.session.key); err != nil {
+			log.Error().Str("path", path).Str("backup", item.BackupPath).Err(err).Msg("Failed to roll back")
+			result.Failed = append(result.Failed, path)
+			continue
+		}
+		log.Info().Str("path", path).Msg("Rolled back")
+		result.Restored = append(result.Restored, path)
+	}
+
+	e.sendProgress(ProgressUpdate{
+		Type: "rollback",
+		Message: e.localizeMessage("RollbackComplete", map[string]interface{}{
+			"AppName":     appName,
+			"Restored":    len(result.Restored),
+			"NotRestored": len(result.Failed) + len(result.NoBackup),
+		}),
+		AppName: appName,
+	})
+
+	return result
+}
@@ -0,0 +1,284 @@
+package cleaner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"Cursor_Windsurf_Reset/config"
+)
+
+const (
+	backupTimestampFormat = "20060102_150405"
+	manifestFileName      = "manifest.json"
+	manifestVersion       = 3
+
+	// 备份中包含令牌等凭据，目录和文件只允许当前用户访问
+	backupDirMode  os.FileMode = 0700
+	backupFileMode os.FileMode = 0600
+)
+
+// Manifest records every backup made during one CleanApplication run
+type Manifest struct {
+	Version   int            `json:"version"`
+	SessionID string         `json:"session_id"`
+	AppName   string         `json:"app_name"`
+	CreatedAt time.Time      `json:"created_at"`
+	Items     []ManifestItem `json:"items"`
+
+	Encryption *EncryptionInfo `json:"encryption,omitempty"` // 为空表示备份未加密
+
+	dir string // 会话目录，加载时填充
+}
+
+// ManifestItem maps a single backup back to the path it was taken from
+type ManifestItem struct {
+	AppName    string      `json:"app_name"`
+	Phase      string      `json:"phase"`
+	SourcePath string      `json:"source_path"`
+	BackupPath string      `json:"backup_path"` // 相对于会话目录
+	IsDir      bool        `json:"is_dir"`
+	Compressed bool        `json:"compressed"`
+	Format     string      `json:"format,omitempty"` // 版本 1 的清单没有该字段，见 BackupFormat
+	SHA256     string      `json:"sha256"`           // 目录为 treeSHA256
+	Size       int64       `json:"size"`
+	Mode       os.FileMode `json:"mode"`
+	ModTime    time.Time   `json:"mtime"`
+	Files      []StoreFile `json:"files,omitempty"` // store 格式的备份内容，backup_path 为空
+	Encrypted  bool        `json:"encrypted,omitempty"`
+}
+
+// BackupFormat returns the format the item was backed up in; 旧清单没有 format 字段，根据 compressed 推断
+func (item ManifestItem) BackupFormat() string {
+	if item.Format != "" {
+		return item.Format
+	}
+	if item.Compressed {
+		return config.BackupFormatZip
+	}
+	return config.BackupFormatDirectory
+}
+
+// backupSession 表示一次重置运行期间的备份会话，所有备份都保存在 <app>/<timestamp>-<shortid>/ 下
+type backupSession struct {
+	dir              string
+	manifest         *Manifest
+	skipCacheBackups bool   // 超出备份预算时缓存目录不再备份
+	key              []byte // 开启加密时由备份密码派生的会话密钥
+}
+
+// beginSession starts a new backup session for appName.
+// 开启备份加密时必须先通过 SetBackupPassphrase 提供密码，否则拒绝开始，避免留下明文凭据
+func (e *Engine) beginSession(appName string) error {
+	now := time.Now()
+	sessionID := fmt.Sprintf("%s-%s", now.Format(backupTimestampFormat), uuid.New().String()[:8])
+
+	session := &backupSession{
+		dir: filepath.Join(e.backupBaseDir, appName, sessionID),
+		manifest: &Manifest{
+			Version:   manifestVersion,
+			SessionID: sessionID,
+			AppName:   appName,
+			CreatedAt: now,
+		},
+	}
+
+	if e.config.BackupOptions.Enabled && e.config.BackupOptions.Encrypt && !e.dryRun {
+		if e.passphrase == "" {
+			return fmt.Errorf(e.localizeMessage("BackupPassphraseRequired", nil))
+		}
+		info, key, err := newEncryptionInfo(e.passphrase)
+		if err != nil {
+			return fmt.Errorf("failed to derive backup key: %w", err)
+		}
+		session.manifest.Encryption = info
+		session.key = key
+	}
+
+	e.session = session
+	return nil
+}
+
+// SetBackupPassphrase sets the passphrase backups are encrypted with when BackupOptions.Encrypt is on,
+// and the passphrase Restore uses for encrypted sessions
+func (e *Engine) SetBackupPassphrase(passphrase string) {
+	e.passphrase = passphrase
+}
+
+// sessionKey derives the key of an encrypted session from the backup passphrase
+func (e *Engine) sessionKey(manifest *Manifest) ([]byte, error) {
+	if manifest.Encryption == nil {
+		return nil, nil
+	}
+	if e.passphrase == "" {
+		return nil, fmt.Errorf(e.localizeMessage("BackupPassphraseRequired", nil))
+	}
+
+	key, ok, err := manifest.Encryption.deriveKey(e.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf(e.localizeMessage("BackupPassphraseIncorrect", nil))
+	}
+	return key, nil
+}
+
+// finishSession writes the manifest of the current session, if anything was backed up
+func (e *Engine) finishSession() {
+	session := e.session
+	e.session = nil
+	if session == nil {
+		return
+	}
+
+	if len(session.manifest.Items) == 0 {
+		// 没有任何备份时不保留空的会话目录
+		os.Remove(session.dir)
+		os.Remove(filepath.Dir(session.dir))
+		return
+	}
+
+	if err := writeManifest(session.dir, session.manifest); err != nil {
+		log.Error().Err(err).Str("session", session.manifest.SessionID).Msg("Failed to write backup manifest")
+		return
+	}
+
+	if e.config.SafetyOptions.CreateRestoreScript && session.manifest.Encryption != nil {
+		// 恢复脚本只能使用系统自带工具，无法解密备份
+		log.Info().Str("session", session.manifest.SessionID).Msg("Backups are encrypted, skipping restore scripts; use -restore instead")
+	} else if e.config.SafetyOptions.CreateRestoreScript {
+		if err := writeRestoreScripts(session.dir, session.manifest); err != nil {
+			log.Error().Err(err).Str("session", session.manifest.SessionID).Msg("Failed to write restore scripts")
+		}
+	}
+
+	e.lastSessionID = session.manifest.SessionID
+	log.Info().Str("session", session.manifest.SessionID).Str("dir", session.dir).Int("items", len(session.manifest.Items)).Msg("Backup session saved")
+}
+
+// recordBackup adds a finished backup to the current session
+func (e *Engine) recordBackup(phase, sourcePath, backupPath string, sourceInfo os.FileInfo, format, sourceSum string, storeFiles []StoreFile) {
+	if e.session == nil {
+		return
+	}
+
+	absSource, err := filepath.Abs(sourcePath)
+	if err != nil {
+		absSource = sourcePath
+	}
+
+	item := ManifestItem{
+		AppName:    e.session.manifest.AppName,
+		Phase:      phase,
+		SourcePath: absSource,
+		BackupPath: filepath.Base(backupPath),
+		IsDir:      sourceInfo.IsDir(),
+		Compressed: format != config.BackupFormatDirectory,
+		Format:     format,
+		SHA256:     sourceSum,
+		Size:       sourceInfo.Size(),
+		Mode:       sourceInfo.Mode(),
+		ModTime:    sourceInfo.ModTime(),
+		Files:      storeFiles,
+		Encrypted:  e.session.key != nil,
+	}
+	if format == config.BackupFormatStore {
+		item.BackupPath = ""
+	}
+	if item.IsDir {
+		item.Size = e.GetDirectorySize(sourcePath)
+	}
+
+	e.session.manifest.Items = append(e.session.manifest.Items, item)
+}
+
+func writeManifest(sessionDir string, manifest *Manifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(sessionDir, manifestFileName), data, backupFileMode); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return nil
+}
+
+// readManifest reads the manifest stored in a session directory
+func readManifest(sessionDir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(sessionDir, manifestFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	manifest.dir = sessionDir
+
+	return &manifest, nil
+}
+
+// loadManifest finds a session by "<id>" or "<app>/<id>" and reads its manifest
+func (e *Engine) loadManifest(sessionID string) (*Manifest, error) {
+	sessionDir, err := e.findSessionDir(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := readManifest(sessionDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// findSessionDir 解析会话ID对应的目录；ID 的每一部分都不能包含路径分隔符，防止访问备份目录之外的文件
+func (e *Engine) findSessionDir(sessionID string) (string, error) {
+	notFound := fmt.Errorf(e.localizeMessage("BackupSessionNotFound", map[string]interface{}{"Session": sessionID}))
+
+	parts := strings.Split(filepath.ToSlash(sessionID), "/")
+	for _, part := range parts {
+		if part == "" || part == "." || part == ".." || filepath.Base(part) != part {
+			return "", notFound
+		}
+	}
+
+	switch len(parts) {
+	case 2:
+		sessionDir := filepath.Join(e.backupBaseDir, parts[0], parts[1])
+		if _, err := os.Stat(filepath.Join(sessionDir, manifestFileName)); err == nil {
+			return sessionDir, nil
+		}
+	case 1:
+		appDirs, err := os.ReadDir(e.backupBaseDir)
+		if err != nil {
+			return "", notFound
+		}
+		for _, appDir := range appDirs {
+			if !appDir.IsDir() {
+				continue
+			}
+			sessionDir := filepath.Join(e.backupBaseDir, appDir.Name(), parts[0])
+			if _, err := os.Stat(filepath.Join(sessionDir, manifestFileName)); err == nil {
+				return sessionDir, nil
+			}
+		}
+	}
+
+	return "", notFound
+}
+
+// GetLastSessionID returns the ID of the most recently saved backup session
+func (e *Engine) GetLastSessionID() string {
+	return e.lastSessionID
+}
@@ -0,0 +1,101 @@
+package cleaner
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// sqliteHeader 每个 SQLite 数据库文件都以这 16 个字节开头
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// isSQLiteDatabase reports whether the file at path is a SQLite database
+func isSQLiteDatabase(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, len(sqliteHeader))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+	return bytes.Equal(header, sqliteHeader)
+}
+
+// snapshotSQLite writes a transactionally consistent copy of a SQLite database using VACUUM INTO.
+// VACUUM INTO 通过 SQLite 读取数据库，尚未检查点的 WAL 内容也会包含在快照中，快照本身不依赖 -wal/-shm 文件。
+// 快照与原文件同名并带有原文件的权限和修改时间，调用方在备份完成后调用 cleanup 删除快照
+func (e *Engine) snapshotSQLite(dbPath string, info os.FileInfo) (snapshotPath string, cleanup func(), err error) {
+	tmpDir, err := os.MkdirTemp(e.backupBaseDir, ".snapshot-")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() { os.RemoveAll(tmpDir) }
+
+	snapshotPath = filepath.Join(tmpDir, filepath.Base(dbPath))
+	if err := vacuumInto(dbPath, snapshotPath); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	if err := checkSQLiteIntegrity(snapshotPath); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	applyFileMetadata(snapshotPath, info.Mode(), info.ModTime())
+
+	log.Debug().Str("path", dbPath).Str("snapshot", snapshotPath).Msg("Created SQLite snapshot")
+	return snapshotPath, cleanup, nil
+}
+
+func vacuumInto(dbPath, snapshotPath string) error {
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("VACUUM INTO ?", snapshotPath); err != nil {
+		return fmt.Errorf("VACUUM INTO failed: %w", err)
+	}
+	return nil
+}
+
+// checkpointSQLite moves the WAL content of a database into the main file, so a plain copy is complete.
+// 仅在无法生成快照时作为后备手段使用
+func checkpointSQLite(dbPath string) {
+	db, err := sql.Open("sqlite", dbPath+"?_pragma=busy_timeout(5000)")
+	if err != nil {
+		log.Warn().Str("path", dbPath).Err(err).Msg("Failed to open database for WAL checkpoint")
+		return
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		log.Warn().Str("path", dbPath).Err(err).Msg("Failed to checkpoint database WAL")
+	}
+}
+
+// checkSQLiteIntegrity 确认快照可以被打开且结构完整，保证恢复后的数据库一定可用
+func checkSQLiteIntegrity(dbPath string) error {
+	db, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var result string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&result); err != nil {
+		return fmt.Errorf("quick_check failed: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("snapshot failed quick_check: %s", result)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package cleaner
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// backupStoreDirName 去重存储位于备份根目录下，以点开头避免与应用目录和旧版本备份混淆
+	backupStoreDirName = ".store"
+	// storeGCGracePeriod 最近写入或复用的对象不会被回收，避免删除另一个正在运行的会话刚写入、尚未记入清单的对象
+	storeGCGracePeriod = time.Hour
+)
+
+// StoreFile is one file, directory or symlink of a backup kept in the deduplicating store.
+// 普通文件的内容以 SHA-256 为键只保存一份，清单中只记录引用
+type StoreFile struct {
+	Path    string      `json:"path"` // 相对于备份源、使用 / 分隔；单个文件备份时为文件名
+	IsDir   bool        `json:"is_dir,omitempty"`
+	Link    string      `json:"link,omitempty"` // 符号链接目标
+	SHA256  string      `json:"sha256,omitempty"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mtime"`
+}
+
+// backupStore is a content-addressed object store shared by all backup sessions
+type backupStore struct {
+	dir string
+}
+
+func (e *Engine) backupStore() *backupStore {
+	return &backupStore{dir: filepath.Join(e.backupBaseDir, backupStoreDirName)}
+}
+
+func (s *backupStore) objectsDir() string {
+	return filepath.Join(s.dir, "objects")
+}
+
+// objectPath returns the path of the object with the given SHA-256; 清单中的哈希不可信，先校验格式防止路径穿越
+func (s *backupStore) objectPath(sum string) (string, error) {
+	if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+		return "", fmt.Errorf("invalid backup store object id: %q", sum)
+	}
+	return filepath.Join(s.objectsDir(), sum[:2], sum), nil
+}
+
+// putFile copies a file into the store unless an object with the same content already exists.
+// 先写入临时文件并在复制的同时计算哈希，保证对象内容与其键一致
+func (s *backupStore) putFile(path string) (sum string, stored bool, err error) {
+	tmpDir := filepath.Join(s.dir, "tmp")
+	if err := os.MkdirAll(tmpDir, backupDirMode); err != nil {
+		return "", false, err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", false, err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(tmpDir, "object-*")
+	if err != nil {
+		return "", false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), src); err != nil {
+		tmp.Close()
+		return "", false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", false, err
+	}
+
+	sum = hex.EncodeToString(hash.Sum(nil))
+	objectPath, _ := s.objectPath(sum)
+
+	if _, err := os.Stat(objectPath); err == nil {
+		// 刷新修改时间，使被复用的对象同样受回收宽限期保护
+		now := time.Now()
+		os.Chtimes(objectPath, now, now)
+		return sum, false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(objectPath), backupDirMode); err != nil {
+		return "", false, err
+	}
+	if err := os.Rename(tmp.Name(), objectPath); err != nil {
+		return "", false, err
+	}
+
+	return sum, true, nil
+}
+
+// createStoreBackup adds sourcePath to the store and returns the entries to record in the manifest
+func (e *Engine) createStoreBackup(sourcePath string) ([]StoreFile, error) {
+	store := e.backupStore()
+	var files []StoreFile
+	var storedBytes, reusedBytes int64
+
+	err := walkBackupSource(sourcePath, func(path, name string, info os.FileInfo) error {
+		file := StoreFile{
+			Path:    name,
+			IsDir:   info.IsDir(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}
+
+		switch {
+		case info.IsDir():
+		case isSymlink(info.Mode()):
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			file.Link = target
+		default:
+			sum, stored, err := store.putFile(path)
+			if err != nil {
+				return err
+			}
+			file.SHA256 = sum
+			file.Size = info.Size()
+			if stored {
+				storedBytes += info.Size()
+			} else {
+				reusedBytes += info.Size()
+			}
+		}
+
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().
+		Str("path", sourcePath).
+		Str("stored", e.FormatSize(storedBytes)).
+		Str("reused", e.FormatSize(reusedBytes)).
+		Msg("Created deduplicated backup")
+	return files, nil
+}
+
+// storeSHA256 re-reads the objects of a store backup and computes its checksum the same way pathSHA256 does for its source
+func (s *backupStore) storeSHA256(files []StoreFile, isDir bool) (string, error) {
+	var entries []treeEntry
+	for _, file := range files {
+		var sum string
+		switch {
+		case file.IsDir:
+			continue
+		case isSymlink(file.Mode):
+			sum = symlinkSHA256(file.Link)
+		default:
+			objectPath, err := s.objectPath(file.SHA256)
+			if err != nil {
+				return "", err
+			}
+			if sum, err = fileSHA256(objectPath); err != nil {
+				return "", err
+			}
+			if sum != file.SHA256 {
+				return "", fmt.Errorf("backup store object is corrupt: %s", objectPath)
+			}
+		}
+		entries = append(entries, treeEntry{file.Path, sum, isSymlink(file.Mode)})
+	}
+
+	if !isDir {
+		if len(entries) != 1 {
+			return "", fmt.Errorf("unexpected store backup with %d entries", len(entries))
+		}
+		return entries[0].sum, nil
+	}
+	return hashTreeEntries(entries), nil
+}
+
+// restoreFromStore writes a store backup back to its source path
+func (e *Engine) restoreFromStore(item ManifestItem) error {
+	store := e.backupStore()
+
+	if !item.IsDir {
+		if len(item.Files) != 1 {
+			return fmt.Errorf("unexpected store backup with %d entries: %s", len(item.Files), item.SourcePath)
+		}
+		return store.restoreFile(item.Files[0], item.SourcePath)
+	}
+
+	var tree treeWriter
+	for _, file := range item.Files {
+		destPath, err := safeJoin(item.SourcePath, file.Path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case file.IsDir:
+			if err := tree.addDir(destPath, file.Mode, file.ModTime); err != nil {
+				return err
+			}
+		case isSymlink(file.Mode):
+			tree.addSymlink(destPath, file.Link)
+		default:
+			if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				return err
+			}
+			if err := store.restoreFile(file, destPath); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tree.finish()
+}
+
+func (s *backupStore) restoreFile(file StoreFile, destPath string) error {
+	objectPath, err := s.objectPath(file.SHA256)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(objectPath)
+	if err != nil {
+		return fmt.Errorf("backup not available: %w", err)
+	}
+	defer src.Close()
+
+	return writeFileFrom(destPath, src, file.Mode, file.ModTime)
+}
+
+// referencedObjects returns the objects referenced by any session manifest below the backup directory.
+// 任何清单无法解析时返回错误，宁可不回收也不能删除仍被引用的对象
+func (e *Engine) referencedObjects() (map[string]bool, error) {
+	referenced := make(map[string]bool)
+
+	appDirs, err := os.ReadDir(e.backupBaseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, appDir := range appDirs {
+		if !appDir.IsDir() || appDir.Name() == backupStoreDirName {
+			continue
+		}
+
+		sessions, err := os.ReadDir(filepath.Join(e.backupBaseDir, appDir.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, session := range sessions {
+			sessionDir := filepath.Join(e.backupBaseDir, appDir.Name(), session.Name())
+			if _, err := os.Stat(filepath.Join(sessionDir, manifestFileName)); err != nil {
+				continue
+			}
+
+			manifest, err := readManifest(sessionDir)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", sessionDir, err)
+			}
+			for _, item := range manifest.Items {
+				for _, file := range item.Files {
+					if file.SHA256 != "" {
+						referenced[file.SHA256] = true
+					}
+				}
+			}
+		}
+	}
+
+	return referenced, nil
+}
+
+// collectStoreGarbage removes store objects no longer referenced by any backup session
+func (e *Engine) collectStoreGarbage() {
+	store := e.backupStore()
+	if _, err := os.Stat(store.dir); err != nil || e.dryRun {
+		return
+	}
+
+	referenced, err := e.referencedObjects()
+	if err != nil {
+		log.Warn().Err(err).Msg("Skipping backup store garbage collection, failed to read backup manifests")
+		return
+	}
+
+	cutoff := time.Now().Add(-storeGCGracePeriod)
+	var removed int
+	var freed int64
+
+	for _, dir := range []string{store.objectsDir(), filepath.Join(store.dir, "tmp")} {
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			if referenced[info.Name()] || !info.ModTime().Before(cutoff) {
+				return nil
+			}
+
+			if err := os.Remove(path); err != nil {
+				log.Warn().Str("path", path).Err(err).Msg("Failed to remove unreferenced backup object")
+				return nil
+			}
+			removed++
+			freed += info.Size()
+			return nil
+		})
+	}
+
+	if removed > 0 {
+		log.Info().Int("objects", removed).Str("freed", e.FormatSize(freed)).Msg("Backup store garbage collected")
+	}
+}
@@ -24,6 +24,8 @@ type Application struct {
 	DisplayName  string              `json:"display_name"`
 	ProcessNames []string            `json:"process_names"`
 	DataPaths    map[string][]string `json:"data_paths"`
+	// EnabledPhases overrides CleaningOptions.Phases for this application, e.g. ["cache"] to only reclaim cache space
+	EnabledPhases []string `json:"enabled_phases,omitempty"`
 }
 
 // CleaningOptions represents cleaning configuration
@@ -35,22 +37,71 @@ type CleaningOptions struct {
 	DatabaseFiles      []string `json:"database_files"`
 	CacheTablePatterns []string `json:"cache_table_patterns"`
 	RegistryPatterns   []string `json:"registry_patterns"`
+
+	// Phases lists the phases to run by name, in order; empty runs the built-in telemetry, database and cache phases.
+	// 通过 cleaner.RegisterPhase 注册的额外阶段也在这里启用
+	Phases []string `json:"phases,omitempty"`
+
+	// PhaseBackupPolicies is keyed by phase (telemetry, database, cache); phases without a policy are always backed up
+	PhaseBackupPolicies map[string]BackupPolicy `json:"phase_backup_policies"`
+	// CacheBackupPolicies is keyed by an entry of CacheDirectories and overrides the cache phase policy
+	CacheBackupPolicies map[string]BackupPolicy `json:"cache_backup_policies"`
+}
+
+// BackupPolicy decides whether the files and directories a phase modifies are backed up first
+type BackupPolicy struct {
+	Mode      string `json:"mode"`                  // always, never or size_limit
+	MaxSizeMB int    `json:"max_size_mb,omitempty"` // size_limit: only paths up to this size are backed up
 }
 
+// Backup policy modes
+const (
+	BackupPolicyAlways    = "always"
+	BackupPolicyNever     = "never"
+	BackupPolicySizeLimit = "size_limit"
+)
+
 // BackupOptions represents backup configuration
 type BackupOptions struct {
-	Enabled         bool `json:"enabled"`
-	Compression     bool `json:"compression"`
-	RetentionDays   int  `json:"retention_days"`
-	MaxBackupSizeMB int  `json:"max_backup_size_mb"`
+	Enabled         bool   `json:"enabled"`
+	Directory       string `json:"directory"` // supports ~, $VAR and %VAR%; empty means DefaultBackupDirectory
+	Compression     bool   `json:"compression"`
+	Format          string `json:"format"` // directory, zip, tar.gz, tar.zst or store; empty falls back to Compression
+	RetentionDays   int    `json:"retention_days"`
+	MaxBackupSizeMB int    `json:"max_backup_size_mb"`
+	BudgetPolicy    string `json:"budget_policy"` // abort, skip_cache or ask
+	Encrypt         bool   `json:"encrypt"`       // encrypt backups with a passphrase, see Engine.SetBackupPassphrase
 }
 
+// DefaultBackupDirectory is where backups are kept unless BackupOptions.Directory is set
+const DefaultBackupDirectory = "~/CursorWindsurf_Advanced_Backups"
+
+// Backup budget policies, applied when a phase's backups would exceed MaxBackupSizeMB
+// or the free space on the backup volume
+const (
+	BudgetPolicyAbort     = "abort"
+	BudgetPolicySkipCache = "skip_cache"
+	BudgetPolicyAsk       = "ask"
+)
+
+// Backup formats. tar archives preserve file modes, modification times, symlinks and empty directories.
+// store keeps file contents once in a content-addressed store shared by all backup sessions
+const (
+	BackupFormatDirectory = "directory"
+	BackupFormatZip       = "zip"
+	BackupFormatTarGz     = "tar.gz"
+	BackupFormatTarZst    = "tar.zst"
+	BackupFormatStore     = "store"
+)
+
 // SafetyOptions represents safety configuration
 type SafetyOptions struct {
 	RequireConfirmation   bool `json:"require_confirmation"`
 	CheckRunningProcesses bool `json:"check_running_processes"`
 	CreateRestoreScript   bool `json:"create_restore_script"`
 	VerifyBackups         bool `json:"verify_backups"`
+	// Transactional puts back every file a run changed, from the run's backups, if the run fails or is cancelled
+	Transactional bool `json:"transactional"`
 }
 
 // LoggingOptions represents logging configuration
@@ -228,12 +279,31 @@ func GetDefaultConfig() *Config {
 				"workspace",
 				"project",
 			},
+			Phases: []string{"telemetry", "database", "cache"},
+			PhaseBackupPolicies: map[string]BackupPolicy{
+				"telemetry": {Mode: BackupPolicyAlways},
+				"database":  {Mode: BackupPolicyAlways},
+				"cache":     {Mode: BackupPolicySizeLimit, MaxSizeMB: 200},
+			},
+			// 这些目录在应用下次启动时会重新生成，清空前无需备份
+			CacheBackupPolicies: map[string]BackupPolicy{
+				"Cache":       {Mode: BackupPolicyNever},
+				"Code Cache":  {Mode: BackupPolicyNever},
+				"GPUCache":    {Mode: BackupPolicyNever},
+				"ShaderCache": {Mode: BackupPolicyNever},
+				"CachedData":  {Mode: BackupPolicyNever},
+				"logs":        {Mode: BackupPolicyNever},
+				"User/logs":   {Mode: BackupPolicyNever},
+			},
 		},
 		BackupOptions: BackupOptions{
 			Enabled:         true,
+			Directory:       DefaultBackupDirectory,
 			Compression:     false,
+			Format:          BackupFormatDirectory,
 			RetentionDays:   30,
 			MaxBackupSizeMB: 1000,
+			BudgetPolicy:    BudgetPolicySkipCache,
 		},
 		SafetyOptions: SafetyOptions{
 			RequireConfirmation:   true,
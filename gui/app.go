@@ -3,6 +3,7 @@ package gui
 import (
 	appi18n "Cursor_Windsurf_Reset/i18n"
 	"context"
+	"errors"
 	"fmt"
 	"github.com/nicksnyder/go-i18n/v2/i18n"
 	"github.com/rs/zerolog"
@@ -32,6 +33,7 @@ type App struct {
 	engine     *cleaner.Engine
 	config     *config.Config
 	logChan    chan string
+	uiChan     chan func()
 	bundle     *i18n.Bundle
 	localizer  *appi18n.LocalizerWrapper
 
@@ -52,6 +54,13 @@ type App struct {
 
 	selectedApps   map[int]bool
 	selectAllCheck *widget.Check
+
+	// backupPassphrase 开启备份加密时由用户输入，只保存在内存中
+	backupPassphrase string
+	// dryRun 由预览对话框中的试运行选项设置
+	dryRun bool
+	// phases 用户在应用列表下方勾选的阶段；nil 表示使用配置中启用的阶段
+	phases []string
 }
 
 type AppInfo struct {
@@ -111,6 +120,7 @@ func NewApp() *App {
 		engine:        engine,
 		config:        cfg,
 		logChan:       logChan,
+		uiChan:        make(chan func(), 100),
 		bundle:        bundle,
 		localizer:     localizer,
 		guiLogger:     guiLogger,
@@ -120,6 +130,7 @@ func NewApp() *App {
 
 	app.setupMainWindow()
 	go app.listenForLogs()
+	go app.listenForUIUpdates()
 
 	go func() {
 		time.Sleep(200 * time.Millisecond)
@@ -171,6 +182,18 @@ func (app *App) listenForLogs() {
 	}
 }
 
+// runOnUI 将后台协程中的界面更新交给界面协程按顺序执行，避免多个协程同时修改控件。
+// fyne 2.4 没有向主循环投递任务的公开接口，所有后台更新都经由这一个协程
+func (app *App) runOnUI(update func()) {
+	app.uiChan <- update
+}
+
+func (app *App) listenForUIUpdates() {
+	for update := range app.uiChan {
+		update()
+	}
+}
+
 func (app *App) setupMainWindow() {
 	app.mainWindow = app.fyneApp.NewWindow(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "WindowTitle"}))
 	app.mainWindow.Resize(fyne.NewSize(800, 600))
@@ -660,103 +683,312 @@ func (app *App) onClean() {
 		}
 	}
 
-	// 创建确认内容
-	confirmContent := container.NewVBox(
+	// 用户改过阶段选择时，所有选中的应用都只执行勾选的阶段
+	if app.phases != nil {
+		if len(app.phases) == 0 {
+			dialog.ShowInformation(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "InfoTitle"}), app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "SelectPhaseToReset"}), app.mainWindow)
+			return
+		}
+		if err := app.engine.SetPhases(app.phases); err != nil {
+			dialog.ShowError(err, app.mainWindow)
+			return
+		}
+	}
+
+	// 在后台计算每个应用的重置计划，计算完成后显示预览；预览关闭或重置结束前不允许再次点击
+	app.cleanButton.Disable()
+	app.progressBar.Show()
+	app.progressBar.SetValue(0)
+	engine := app.engine
+	go func() {
+		plans := make(map[string]*cleaner.Plan)
+		for _, appInfo := range selectedApps {
+			status := app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "StatusPlanning", TemplateData: map[string]interface{}{"AppName": appInfo.DisplayName}})
+			app.runOnUI(func() {
+				app.statusLabel.SetText(status)
+			})
+
+			plan, err := engine.PlanApplication(appInfo.Name)
+			if err != nil {
+				app.logMessage("ERROR", "PlanFailed", map[string]interface{}{"AppName": appInfo.DisplayName, "Error": err})
+				continue
+			}
+			plans[appInfo.Name] = plan
+		}
+
+		app.runOnUI(func() {
+			app.progressBar.Hide()
+			app.statusLabel.SetText(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "Ready"}))
+			app.showPlanPreview(engine, selectedApps, plans)
+		})
+	}()
+}
+
+// showPlanPreview 显示计划预览树，用户确认后只执行仍被勾选的动作；未开始重置时恢复重置按钮
+func (app *App) showPlanPreview(engine *cleaner.Engine, selectedApps []AppInfo, plans map[string]*cleaner.Plan) {
+	tree := newPlanTree(app, selectedApps, plans)
+
+	hint := widget.NewLabel(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "PlanPreviewHint"}))
+	hint.Wrapping = fyne.TextWrapWord
+
+	dryRunCheck := widget.NewCheck(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "DryRunPreview"}), nil)
+	dryRunCheck.SetChecked(app.dryRun)
+
+	header := container.NewVBox(
 		widget.NewLabelWithStyle(
 			fmt.Sprintf(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmResetCount"}), len(selectedApps)),
 			fyne.TextAlignCenter,
 			fyne.TextStyle{Bold: true},
 		),
+		hint,
 		widget.NewSeparator(),
 	)
-
-	// 添加选中的应用名称
-	for _, appInfo := range selectedApps {
-		confirmContent.Add(widget.NewLabel("• " + appInfo.DisplayName))
-	}
-
-	// 添加操作说明
-	confirmContent.Add(widget.NewSeparator())
-	confirmContent.Add(widget.NewLabel(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmResetDescription"})))
-	confirmContent.Add(widget.NewLabel(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmResetDeviceID"})))
-	confirmContent.Add(widget.NewLabel(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmResetAccountRecords"})))
-	confirmContent.Add(widget.NewLabel(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmResetCacheData"})))
-	confirmContent.Add(widget.NewLabel(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmResetBackup"})))
-	confirmContent.Add(widget.NewSeparator())
-	confirmContent.Add(widget.NewLabelWithStyle(
-		app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmBackupLocation"}),
-		fyne.TextAlignCenter,
-		fyne.TextStyle{Italic: true},
-	))
+	footer := container.NewVBox(
+		widget.NewSeparator(),
+		dryRunCheck,
+		widget.NewLabelWithStyle(
+			app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmBackupLocation", TemplateData: map[string]interface{}{"Dir": engine.GetBackupDirectory()}}),
+			fyne.TextAlignCenter,
+			fyne.TextStyle{Italic: true},
+		),
+	)
 
 	// 显示确认对话框
 	customConfirm := dialog.NewCustomConfirm(
 		app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmResetTitle"}),
 		app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmExecute"}),
 		app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "Cancel"}),
-		confirmContent,
+		container.NewBorder(header, footer, nil, nil, tree.tree),
 		func(confirm bool) {
-			if confirm {
-				// 逐个重置选中的应用
-				for _, appInfo := range selectedApps {
-					app.performCleanup(appInfo)
+			cancelled := func() {
+				app.runOnUI(app.updateCleanButton)
+			}
+			if !confirm {
+				cancelled()
+				return
+			}
+
+			app.dryRun = dryRunCheck.Checked
+
+			// 检查备份目录是否可写；试运行不写入任何文件，不需要备份目录
+			if !app.dryRun {
+				if err := engine.CheckBackupDirectory(); err != nil {
+					dialog.ShowError(err, app.mainWindow)
+					cancelled()
+					return
+				}
+			}
+
+			// 没有勾选任何动作的应用跳过
+			var apps []AppInfo
+			selectedPlans := make(map[string]*cleaner.Plan)
+			for _, appInfo := range selectedApps {
+				if plan := tree.selectedPlan(appInfo.Name); plan != nil {
+					apps = append(apps, appInfo)
+					selectedPlans[appInfo.Name] = plan
 				}
 			}
+			if len(apps) == 0 {
+				cancelled()
+				return
+			}
+
+			run := func() {
+				app.performCleanup(apps, selectedPlans)
+			}
+
+			// 试运行不创建备份，不需要备份密码
+			if app.dryRun {
+				run()
+			} else {
+				app.withBackupPassphrase(run, cancelled)
+			}
 		},
 		app.mainWindow,
 	)
 
+	customConfirm.Resize(fyne.NewSize(720, 560))
 	customConfirm.Show()
 }
 
-// performCleanup performs the actual cleanup operation
-func (app *App) performCleanup(appInfo AppInfo) {
+// withBackupPassphrase 开启备份加密时先让用户输入两次备份密码再执行 next，用户取消或输入有误时执行 cancelled；密码只保存在内存中，本次运行内不再重复询问
+func (app *App) withBackupPassphrase(next, cancelled func()) {
+	if !app.config.BackupOptions.Enabled || !app.config.BackupOptions.Encrypt || app.backupPassphrase != "" {
+		next()
+		return
+	}
+
+	passphrase := widget.NewPasswordEntry()
+	confirmation := widget.NewPasswordEntry()
+	hint := widget.NewLabel(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "BackupPassphraseHint"}))
+	hint.Wrapping = fyne.TextWrapWord
+
+	form := container.NewVBox(
+		hint,
+		widget.NewForm(
+			widget.NewFormItem(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "BackupPassphrase"}), passphrase),
+			widget.NewFormItem(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "BackupPassphraseConfirm"}), confirmation),
+		),
+	)
+
+	passphraseDialog := dialog.NewCustomConfirm(
+		app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "BackupPassphraseTitle"}),
+		app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "ConfirmExecute"}),
+		app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "Cancel"}),
+		form,
+		func(confirm bool) {
+			if !confirm {
+				cancelled()
+				return
+			}
+			if passphrase.Text == "" {
+				dialog.ShowError(fmt.Errorf(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "BackupPassphraseRequired"})), app.mainWindow)
+				cancelled()
+				return
+			}
+			if passphrase.Text != confirmation.Text {
+				dialog.ShowError(fmt.Errorf(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "BackupPassphraseMismatch"})), app.mainWindow)
+				cancelled()
+				return
+			}
+
+			app.backupPassphrase = passphrase.Text
+			next()
+		},
+		app.mainWindow,
+	)
+	passphraseDialog.Resize(fyne.NewSize(420, 0))
+	passphraseDialog.Show()
+}
+
+// performCleanup applies the reviewed plans of the selected applications one after another.
+// 所有应用共用本次重置创建的引擎，在同一个后台协程中依次执行，进度只由一个 monitorProgress 读取；
+// app.engine 可能仍被其他后台协程使用，不替换它。重置按钮在 onClean 中禁用，结束后恢复
+func (app *App) performCleanup(selectedApps []AppInfo, plans map[string]*cleaner.Plan) {
+	engine := cleaner.NewEngine(app.config, app.dryRun, false, app.localizer)
+	engine.SetBudgetPrompt(app.confirmBackupBudget)
+	engine.SetBackupPassphrase(app.backupPassphrase)
+
+	app.progressBar.Show()
+	app.progressBar.SetValue(0)
+
+	stopMonitor := app.monitorProgress(engine)
+
+	go func() {
+		for _, appInfo := range selectedApps {
+			app.applyPlan(engine, appInfo, plans[appInfo.Name])
+		}
+
+		stopMonitor()
+		app.runOnUI(app.updateCleanButton)
+	}()
+}
+
+// applyPlan applies the plan of one application and logs the outcome; 在 performCleanup 的后台协程中调用
+func (app *App) applyPlan(engine *cleaner.Engine, appInfo AppInfo, plan *cleaner.Plan) {
 	app.logMessage("INFO", "LogStartResetting", map[string]interface{}{
 		"AppName": appInfo.DisplayName,
 	})
 
-	app.statusLabel.SetText(app.localizer.MustLocalize(&i18n.LocalizeConfig{
+	status := app.localizer.MustLocalize(&i18n.LocalizeConfig{
 		MessageID: "StatusResetting",
 		TemplateData: map[string]interface{}{
 			"AppName": appInfo.DisplayName,
 		},
-	}))
-	app.progressBar.Show()
-	app.progressBar.SetValue(0)
+	})
+	app.runOnUI(func() {
+		app.statusLabel.SetText(status)
+		app.progressBar.SetValue(0)
+	})
 
-	// Update engine settings
-	app.engine = cleaner.NewEngine(app.config, false, false, app.localizer)
+	result, err := engine.ApplyPlan(context.Background(), plan)
+	if result != nil {
+		if report := engine.GenerateCacheCleaningReport(result); report != "" {
+			app.log("INFO", report)
+		}
+	}
+	var rollback *cleaner.RollbackError
+	if errors.As(err, &rollback) {
+		// 事务模式：逐项列出撤销的修改
+		app.logMessage("ERROR", "ResetFailed", map[string]interface{}{
+			"AppName": appInfo.DisplayName,
+			"Error":   rollback.Cause,
+		})
+		for _, path := range rollback.Restored {
+			app.logMessage("INFO", "RolledBackItem", map[string]interface{}{"Path": path})
+		}
+		for _, path := range append(rollback.Failed, rollback.NoBackup...) {
+			app.logMessage("ERROR", "NotRolledBackItem", map[string]interface{}{"Path": path})
+		}
+	} else if err != nil {
+		app.logMessage("ERROR", "ResetFailed", map[string]interface{}{
+			"AppName": appInfo.DisplayName,
+			"Error":   err,
+		})
+	} else if app.dryRun {
+		app.logMessage("INFO", "DryRunComplete", map[string]interface{}{
+			"AppName": appInfo.DisplayName,
+		})
+	} else {
+		app.logMessage("INFO", "ResetComplete", map[string]interface{}{
+			"AppName": appInfo.DisplayName,
+		})
+		// 项目主页和免责声明现在在进度达到100%后通过monitorProgress显示
+	}
+}
 
-	// Start progress monitoring
-	go app.monitorProgress()
+// confirmBackupBudget 在备份超出预算时询问用户是否继续，由后台重置协程调用并等待用户选择
+func (app *App) confirmBackupBudget(budget cleaner.BackupBudget) bool {
+	free := "-"
+	if budget.Free >= 0 {
+		free = app.engine.FormatSize(budget.Free)
+	}
 
-	// Perform cleanup in background
-	go func() {
-		err := app.engine.CleanApplication(context.Background(), appInfo.Name)
-		if err != nil {
-			app.logMessage("ERROR", "ResetFailed", map[string]interface{}{
-				"AppName": appInfo.DisplayName,
-				"Error":   err,
-			})
-		} else {
-			app.logMessage("INFO", "ResetComplete", map[string]interface{}{
-				"AppName": appInfo.DisplayName,
-			})
-			// 项目主页和免责声明现在在进度达到100%后通过monitorProgress显示
-		}
-	}()
+	messageID := "BackupBudgetExceededPhase"
+	if budget.Phase == cleaner.PhaseCache {
+		messageID = "BackupBudgetExceededCache"
+	}
+
+	message := app.localizer.MustLocalize(&i18n.LocalizeConfig{
+		MessageID: messageID,
+		TemplateData: map[string]interface{}{
+			"AppName":   budget.AppName,
+			"Phase":     budget.Phase,
+			"Estimated": app.engine.FormatSize(budget.Estimated),
+			"Used":      app.engine.FormatSize(budget.Used),
+			"Limit":     app.engine.FormatSize(budget.Limit),
+			"Free":      free,
+		},
+	})
+
+	answer := make(chan bool, 1)
+	app.runOnUI(func() {
+		dialog.ShowConfirm(
+			app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "BackupBudgetExceededTitle"}),
+			message,
+			func(confirm bool) {
+				answer <- confirm
+			},
+			app.mainWindow,
+		)
+	})
+
+	return <-answer
 }
 
-// monitorProgress monitors cleanup progress
-func (app *App) monitorProgress() {
-	progressChan := app.engine.GetProgressChannel()
+// monitorProgress shows the progress updates of engine until the returned function is called
+func (app *App) monitorProgress(engine *cleaner.Engine) func() {
+	updates := engine.GetProgressChannel()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
 	var completedApps []string // 记录已完成的应用
 
-	for update := range progressChan {
-		app.progressBar.SetValue(update.Progress / 100.0)
-
-		// 状态消息可能已经是国际化的，直接使用
-		app.statusLabel.SetText(update.Message)
+	show := func(update cleaner.ProgressUpdate) {
+		app.runOnUI(func() {
+			app.progressBar.SetValue(update.Progress / 100.0)
+			// 状态消息可能已经是国际化的，直接使用
+			app.statusLabel.SetText(update.Message)
+		})
 
 		app.logMessage("INFO", "LogResetProgress", map[string]interface{}{
 			"Phase":   update.Phase,
@@ -767,21 +999,42 @@ func (app *App) monitorProgress() {
 		// 检查是否达到100%进度
 		if update.Progress >= 100.0 && update.AppName != "" {
 			// 检查是否已经处理过这个应用
-			alreadyProcessed := false
 			for _, completedApp := range completedApps {
 				if completedApp == update.AppName {
-					alreadyProcessed = true
-					break
+					return
 				}
 			}
 
 			// 如果没有处理过，则显示项目主页和免责声明
-			if !alreadyProcessed {
-				completedApps = append(completedApps, update.AppName)
-				// 在单独的goroutine中执行，避免阻塞进度监控
-				go app.showProjectInfoAfterCompletion()
+			completedApps = append(completedApps, update.AppName)
+			// 在单独的goroutine中执行，避免阻塞进度监控
+			go app.showProjectInfoAfterCompletion()
+		}
+	}
+
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case update := <-updates:
+				show(update)
+			case <-stop:
+				// 引擎同步发送进度，停止时通道中剩余的更新都已发送完毕
+				for {
+					select {
+					case update := <-updates:
+						show(update)
+					default:
+						return
+					}
+				}
 			}
 		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
 	}
 }
 
@@ -794,6 +1047,9 @@ func (app *App) onConfig() {
 	backupEnabledCheck := widget.NewCheck("启用备份功能", nil)
 	backupEnabledCheck.SetChecked(app.config.BackupOptions.Enabled)
 
+	backupEncryptCheck := widget.NewCheck("", nil)
+	backupEncryptCheck.SetChecked(app.config.BackupOptions.Encrypt)
+
 	backupKeepDays := widget.NewEntry()
 	backupKeepDays.SetText(fmt.Sprintf("%d", app.config.BackupOptions.RetentionDays))
 
@@ -801,10 +1057,15 @@ func (app *App) onConfig() {
 	confirmCheck := widget.NewCheck("操作需要确认", nil)
 	confirmCheck.SetChecked(app.config.SafetyOptions.RequireConfirmation)
 
+	transactionalCheck := widget.NewCheck("", nil)
+	transactionalCheck.SetChecked(app.config.SafetyOptions.Transactional)
+
 	// 添加到表单
 	configForm.Append(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "EnableBackup"}), backupEnabledCheck)
+	configForm.Append(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "EncryptBackups"}), backupEncryptCheck)
 	configForm.Append(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "RetentionDays"}), backupKeepDays)
 	configForm.Append(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "RequireConfirmation"}), confirmCheck)
+	configForm.Append(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "TransactionalRuns"}), transactionalCheck)
 
 	// Language selection
 	langSelector := widget.NewSelect([]string{"en", "zh"}, func(s string) {
@@ -819,11 +1080,13 @@ func (app *App) onConfig() {
 		if save {
 			// 更新配置
 			app.config.BackupOptions.Enabled = backupEnabledCheck.Checked
+			app.config.BackupOptions.Encrypt = backupEncryptCheck.Checked
 			days, err := strconv.Atoi(backupKeepDays.Text)
 			if err == nil && days > 0 {
 				app.config.BackupOptions.RetentionDays = days
 			}
 			app.config.SafetyOptions.RequireConfirmation = confirmCheck.Checked
+			app.config.SafetyOptions.Transactional = transactionalCheck.Checked
 
 			err = config.SaveConfig(app.config, "")
 			if err != nil {
@@ -1209,7 +1472,54 @@ func (app *App) createAppListArea() *fyne.Container {
 	)
 
 	// 最终的应用列表容器，使用Border布局
-	return container.NewBorder(listHeader, nil, nil, nil, listScroll)
+	return container.NewBorder(listHeader, app.createPhaseSelection(), nil, nil, listScroll)
+}
+
+// createPhaseSelection 列出所有已注册的阶段，初始勾选配置中启用的阶段
+func (app *App) createPhaseSelection() fyne.CanvasObject {
+	selected := make(map[string]bool)
+	if app.phases != nil {
+		for _, phase := range app.phases {
+			selected[phase] = true
+		}
+	} else {
+		for _, phase := range app.engine.EnabledPhases("") {
+			selected[phase] = true
+		}
+	}
+
+	row := container.NewHBox(widget.NewLabelWithStyle(app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "PhaseSelection"}), fyne.TextAlignLeading, fyne.TextStyle{Bold: true}))
+	for _, phase := range cleaner.RegisteredPhases() {
+		phase := phase
+		check := widget.NewCheck(app.phaseLabel(phase), nil)
+		check.SetChecked(selected[phase])
+		check.OnChanged = func(on bool) {
+			selected[phase] = on
+			// 按注册顺序记录勾选的阶段
+			app.phases = []string{}
+			for _, name := range cleaner.RegisteredPhases() {
+				if selected[name] {
+					app.phases = append(app.phases, name)
+				}
+			}
+		}
+		row.Add(check)
+	}
+	return row
+}
+
+// phaseLabel 返回阶段的显示名称
+func (app *App) phaseLabel(phase string) string {
+	messageID, builtin := map[string]string{
+		cleaner.PhaseTelemetry: "PlanPhaseTelemetry",
+		cleaner.PhaseDatabase:  "PlanPhaseDatabase",
+		cleaner.PhaseCache:     "PlanPhaseCache",
+	}[phase]
+	if !builtin {
+		// 通过 cleaner.RegisterPhase 注册的阶段没有翻译，显示其名称
+		return phase
+	}
+	return app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: messageID})
 }
 
 // refreshAppList refreshes the application list area
@@ -0,0 +1,227 @@
+package gui
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"Cursor_Windsurf_Reset/cleaner"
+
+	"fyne.io/fyne/v2"
+	"fyne.io/fyne/v2/widget"
+	"github.com/nicksnyder/go-i18n/v2/i18n"
+)
+
+// actionRef identifies an action of one of the previewed plans
+type actionRef struct {
+	appName string
+	id      int
+}
+
+// planTree shows the plans of the selected applications as a tree: 应用 → 阶段 → 文件/目录 → 动作 → 行数估算。
+// 每个节点都有复选框，取消勾选的动作不会被执行
+type planTree struct {
+	app     *App
+	plans   map[string]*cleaner.Plan
+	enabled map[actionRef]bool
+
+	children map[widget.TreeNodeID][]widget.TreeNodeID
+	labels   map[widget.TreeNodeID]string
+	// actions 每个节点下的所有动作；估算节点只用于显示，没有自己的动作
+	actions  map[widget.TreeNodeID][]actionRef
+	infoOnly map[widget.TreeNodeID]bool
+
+	tree *widget.Tree
+}
+
+func newPlanTree(app *App, apps []AppInfo, plans map[string]*cleaner.Plan) *planTree {
+	t := &planTree{
+		app:      app,
+		plans:    plans,
+		enabled:  make(map[actionRef]bool),
+		children: make(map[widget.TreeNodeID][]widget.TreeNodeID),
+		labels:   make(map[widget.TreeNodeID]string),
+		actions:  make(map[widget.TreeNodeID][]actionRef),
+		infoOnly: make(map[widget.TreeNodeID]bool),
+	}
+
+	for _, appInfo := range apps {
+		plan := plans[appInfo.Name]
+		if plan == nil {
+			continue
+		}
+
+		appNode := "app/" + appInfo.Name
+		t.addNode("", appNode, appInfo.DisplayName)
+		if len(plan.Actions) == 0 {
+			t.addNode(appNode, appNode+"/empty", app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: "PlanEmpty"}))
+			t.infoOnly[appNode+"/empty"] = true
+			continue
+		}
+
+		for _, phase := range plan.PhaseNames() {
+			actions := plan.PhaseActions(phase)
+			if len(actions) == 0 {
+				continue
+			}
+
+			phaseNode := appNode + "/" + phase
+			t.addNode(appNode, phaseNode, t.app.phaseLabel(phase))
+
+			for _, action := range actions {
+				ref := actionRef{appName: appInfo.Name, id: action.ID}
+				t.enabled[ref] = true
+
+				fileNode := phaseNode + "/" + action.Path
+				if _, exists := t.labels[fileNode]; !exists {
+					t.addNode(phaseNode, fileNode, t.pathLabel(plan, action))
+				}
+
+				actionNode := appNode + "/#" + strconv.Itoa(action.ID)
+				t.addNode(fileNode, actionNode, t.actionLabel(action))
+				for _, node := range []widget.TreeNodeID{appNode, phaseNode, fileNode, actionNode} {
+					t.actions[node] = append(t.actions[node], ref)
+				}
+
+				for i, estimate := range action.Estimates {
+					estimateNode := actionNode + "/" + strconv.Itoa(i)
+					t.addNode(actionNode, estimateNode, estimateLabel(estimate))
+					t.infoOnly[estimateNode] = true
+					t.actions[estimateNode] = []actionRef{ref}
+				}
+			}
+		}
+	}
+
+	t.tree = widget.NewTree(
+		func(id widget.TreeNodeID) []widget.TreeNodeID {
+			return t.children[id]
+		},
+		func(id widget.TreeNodeID) bool {
+			return len(t.children[id]) > 0
+		},
+		func(branch bool) fyne.CanvasObject {
+			return widget.NewCheck("", nil)
+		},
+		func(id widget.TreeNodeID, branch bool, object fyne.CanvasObject) {
+			check := object.(*widget.Check)
+			check.OnChanged = nil
+			check.SetText(t.labels[id])
+			check.SetChecked(t.allEnabled(id))
+			if t.infoOnly[id] {
+				check.Disable()
+				return
+			}
+			check.Enable()
+			check.OnChanged = func(on bool) {
+				t.setEnabled(id, on)
+				t.tree.Refresh()
+			}
+		},
+	)
+
+	// 默认展开到文件一级
+	for _, appNode := range t.children[""] {
+		t.tree.OpenBranch(appNode)
+		for _, phaseNode := range t.children[appNode] {
+			t.tree.OpenBranch(phaseNode)
+		}
+	}
+
+	return t
+}
+
+func (t *planTree) addNode(parent, id widget.TreeNodeID, label string) {
+	t.children[parent] = append(t.children[parent], id)
+	t.labels[id] = label
+}
+
+// allEnabled 节点下的所有动作都启用时勾选
+func (t *planTree) allEnabled(id widget.TreeNodeID) bool {
+	refs := t.actions[id]
+	for _, ref := range refs {
+		if !t.enabled[ref] {
+			return false
+		}
+	}
+	return len(refs) > 0
+}
+
+func (t *planTree) setEnabled(id widget.TreeNodeID, on bool) {
+	for _, ref := range t.actions[id] {
+		t.enabled[ref] = on
+	}
+}
+
+// selectedPlan returns the plan of appName reduced to the checked actions, or nil if nothing is checked
+func (t *planTree) selectedPlan(appName string) *cleaner.Plan {
+	plan := t.plans[appName]
+	if plan == nil {
+		return nil
+	}
+
+	selected := plan.Filter(func(action cleaner.Action) bool {
+		return t.enabled[actionRef{appName: appName, id: action.ID}]
+	})
+	if len(selected.Actions) == 0 {
+		return nil
+	}
+	return selected
+}
+
+// pathLabel 显示相对于应用数据目录的路径，目录附带大小
+func (t *planTree) pathLabel(plan *cleaner.Plan, action cleaner.Action) string {
+	label := action.Path
+	if rel, err := filepath.Rel(plan.AppPath, action.Path); err == nil {
+		label = rel
+	}
+	if action.Size > 0 {
+		label += " (" + t.app.engine.FormatSize(action.Size) + ")"
+	}
+	return label
+}
+
+func (t *planTree) actionLabel(action cleaner.Action) string {
+	data := map[string]interface{}{
+		"Key":    action.Key,
+		"Table":  action.Table,
+		"Column": action.Column,
+		"Count":  action.Count,
+		"Size":   t.app.engine.FormatSize(action.Size),
+	}
+
+	messageID := ""
+	switch action.Type {
+	case cleaner.ActionBackup:
+		messageID = "PlanActionBackup"
+	case cleaner.ActionUpdateKey:
+		messageID = "PlanActionUpdateKey"
+	case cleaner.ActionDeleteKey:
+		messageID = "PlanActionDeleteKey"
+	case cleaner.ActionDeleteRows:
+		messageID = "PlanActionDeleteRows"
+		if len(action.Columns) == 0 {
+			messageID = "PlanActionDeleteTable"
+		}
+	case cleaner.ActionClearColumn:
+		messageID = "PlanActionClearColumn"
+	case cleaner.ActionClearDir:
+		messageID = "PlanActionClearDir"
+	default:
+		return action.Description()
+	}
+	return t.app.localizer.MustLocalize(&i18n.LocalizeConfig{MessageID: messageID, TemplateData: data})
+}
+
+// estimateLabel 显示一个列和关键词组合匹配的行数和示例键
+func estimateLabel(estimate cleaner.RowEstimate) string {
+	label := fmt.Sprintf("%d", estimate.Rows)
+	if estimate.Column != "" {
+		label = fmt.Sprintf("%s LIKE '%%%s%%': %d", estimate.Column, estimate.Keyword, estimate.Rows)
+	}
+	if len(estimate.SampleKeys) > 0 {
+		label += " — " + strings.Join(estimate.SampleKeys, ", ")
+	}
+	return label
+}
@@ -1,11 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"Cursor_Windsurf_Reset/cleaner"
 	"Cursor_Windsurf_Reset/config"
@@ -27,15 +33,80 @@ func main() {
 		noConfirm  = flag.Bool("no-confirm", false, "Skip confirmation prompts")
 		dryRun     = flag.Bool("dry-run", false, "Preview actions without making changes")
 		verbose    = flag.Bool("verbose", false, "Show detailed output")
+		format     = flag.String("output", outputText, "Output format: text, json or ndjson. json writes one result document to stdout, ndjson also streams progress; messages and prompts go to stderr")
 		cli        = flag.Bool("cli", false, "Use command line interface instead of GUI")
 		version    = flag.Bool("version", false, "Show version information")
 		testSQLite = flag.String("test-sqlite", "", "Test SQLite database connection (provide database path)")
+		restore    = flag.String("restore", "", "Restore application data from a backup session (provide <session> or <app>/<session>)")
+
+		listBackups     = flag.Bool("list-backups", false, "List backup sessions with application, date, size and item count")
+		inspectBackup   = flag.String("inspect-backup", "", "Show the contents of a backup session (provide <session> or <app>/<session>)")
+		diffBackup      = flag.String("diff", "", "Show what changed between a backup session and the current files (provide <session> or <app>/<session>)")
+		deleteBackup    = flag.String("delete-backup", "", "Delete a backup session (provide <session> or <app>/<session>)")
+		pruneBackups    = flag.Bool("prune-backups", false, "Remove backup sessions selected by -keep-last, -max-backup-size-mb and -older-than-days")
+		keepLast        = flag.Int("keep-last", 0, "With -prune-backups: number of newest sessions to keep per application")
+		maxBackupSizeMB = flag.Int("max-backup-size-mb", 0, "With -prune-backups: remove the oldest sessions until all backups fit into this size")
+		olderThanDays   = flag.Int("older-than-days", 0, "With -prune-backups: remove sessions older than this many days")
+
+		backupDir      = flag.String("backup-dir", "", "Directory to keep backups in (overrides backup_options.directory)")
+		passphraseFile = flag.String("passphrase-file", "", "File containing the backup encryption passphrase (default: $"+backupPassphraseEnv+")")
+		transactional  = flag.Bool("transactional", false, "Put back every file a run changed if the run fails or is cancelled (overrides safety_options.transactional)")
+
+		planFile  = flag.String("plan", "", "With -clean: write the cleaning plan to this JSON file instead of cleaning")
+		applyFile = flag.String("apply", "", "Apply a cleaning plan written by -plan")
+		review    = flag.Bool("review", false, "Review the cleaning plan and switch actions on or off before applying")
+		estimate  = flag.Bool("estimate", false, "With -clean: show how many database rows each table, column and keyword would remove, without cleaning")
+		phases    = flag.String("phases", "", "Comma-separated phases to run, e.g. cache,database (default: enabled_phases of the application, then cleaning_options.phases)")
 	)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprint(flag.CommandLine.Output(), exitCodesHelp)
+	}
 	flag.Parse()
 
+	// 与下面的分发顺序一致，-output json 的结果中报告该命令名
+	command := "gui"
+	switch {
+	case *version:
+		command = "version"
+	case *testSQLite != "":
+		command = "test-sqlite"
+	case *restore != "":
+		command = "restore"
+	case *listBackups:
+		command = "list-backups"
+	case *inspectBackup != "":
+		command = "inspect-backup"
+	case *diffBackup != "":
+		command = "diff"
+	case *deleteBackup != "":
+		command = "delete-backup"
+	case *planFile != "":
+		command = "plan"
+	case *estimate:
+		command = "estimate"
+	case *applyFile != "":
+		command = "apply"
+	case *pruneBackups:
+		command = "prune-backups"
+	case *discover:
+		command = "discover"
+	case *cli || *clean != "" || *cleanAll:
+		command = "clean"
+	}
+
+	if err := setupOutput(*format, command); err != nil {
+		fail(exitUsage, "%v", err)
+	}
+	if command == "gui" && output.machineReadable() {
+		fail(exitUsage, "-output %s needs a command line mode such as -discover or -clean", *format)
+	}
+
 	if *version {
-		fmt.Println("Cursor & Windsurf Data Cleaner v2.0.0 (Go)")
-		fmt.Println("Built with Go and Fyne GUI framework")
+		fmt.Fprintln(output.text, "Cursor & Windsurf Data Cleaner v"+appVersion+" (Go)")
+		fmt.Fprintln(output.text, "Built with Go and Fyne GUI framework")
+		finish(exitOK, "", map[string]string{"version": appVersion})
 		return
 	}
 
@@ -46,7 +117,7 @@ func main() {
 	zerolog.SetGlobalLevel(logLevel)
 
 	consoleWriter := zerolog.ConsoleWriter{
-		Out:             os.Stdout,
+		Out:             output.text, // -output json/ndjson 时为 stderr
 		NoColor:         false,
 		TimeFormat:      "",
 		FormatTimestamp: func(i interface{}) string { return "" },
@@ -64,49 +135,92 @@ func main() {
 
 	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to load configuration")
+		fail(exitFailure, "Failed to load configuration: %v", err)
+	}
+
+	if *backupDir != "" {
+		cfg.BackupOptions.Directory = *backupDir
+	}
+	if *transactional {
+		cfg.SafetyOptions.Transactional = true
 	}
 
 	bundle, err := appi18n.Init("i18n")
 	if err != nil {
-		log.Fatal().Err(err).Msg("Failed to initialize i18n")
+		fail(exitFailure, "Failed to initialize i18n: %v", err)
 	}
 
 	localizer := appi18n.NewLocalizer(bundle, "en")
 
 	engine := cleaner.NewEngine(cfg, *dryRun, *verbose, localizer)
 
-	if *testSQLite != "" {
-		fmt.Printf("Testing SQLite connection to: %s\n", *testSQLite)
-		err := engine.TestSQLiteConnection(*testSQLite)
-		if err != nil {
-			fmt.Printf("❌ SQLite test failed: %v\n", err)
-			os.Exit(1)
-		}
-		fmt.Println("✅ SQLite test successful")
-		return
+	passphrase, err := readBackupPassphrase(*passphraseFile)
+	if err != nil {
+		fail(exitFailure, "Failed to read backup passphrase: %v", err)
 	}
+	engine.SetBackupPassphrase(passphrase)
 
-	if *cli || *discover || *clean != "" || *cleanAll {
-		runCLI(engine, cfg, discover, clean, cleanAll, noConfirm, dryRun)
-		return
+	phaseNames := parsePhaseList(*phases)
+	if err := engine.SetPhases(phaseNames); err != nil {
+		fail(exitUsage, "Invalid -phases: %v", err)
 	}
 
-	runGUI()
+	// 所有提示共用同一个缓冲读取器，否则前一个读取器缓冲的输入会丢失
+	stdin := bufio.NewReader(os.Stdin)
+
+	switch command {
+	case "test-sqlite":
+		fmt.Fprintf(output.text, "Testing SQLite connection to: %s\n", *testSQLite)
+		if err := engine.TestSQLiteConnection(*testSQLite); err != nil {
+			fail(exitFailure, "SQLite test failed: %v", err)
+		}
+		fmt.Fprintln(output.text, "✅ SQLite test successful")
+		finish(exitOK, "", map[string]string{"path": *testSQLite})
+	case "restore":
+		runRestore(engine, cfg, stdin, *restore, *noConfirm)
+	case "list-backups":
+		listBackupSessions(engine)
+	case "inspect-backup":
+		inspectBackupSession(engine, *inspectBackup)
+	case "diff":
+		diffBackupSession(engine, *diffBackup)
+	case "delete-backup":
+		deleteBackupSession(engine, cfg, stdin, *deleteBackup, *noConfirm)
+	case "plan":
+		writeCleaningPlan(engine, *clean, *planFile)
+	case "estimate":
+		estimateDatabaseCleaning(engine, *clean)
+	case "apply":
+		applyCleaningPlan(engine, cfg, stdin, *applyFile, phaseNames, *noConfirm, *dryRun, *review)
+	case "prune-backups":
+		pruneBackupSessions(engine, cfg, stdin, cleaner.PruneOptions{
+			KeepLast:  *keepLast,
+			MaxSize:   int64(*maxBackupSizeMB) * 1024 * 1024,
+			OlderThan: time.Duration(*olderThanDays) * 24 * time.Hour,
+		}, *noConfirm)
+	case "discover", "clean":
+		runCLI(engine, cfg, stdin, discover, clean, cleanAll, noConfirm, dryRun, review)
+	default:
+		runGUI()
+	}
 }
 
-func runCLI(engine *cleaner.Engine, cfg *config.Config,
-	discover *bool, clean *string, cleanAll *bool, noConfirm *bool, dryRun *bool) {
+// appVersion is reported by -version
+const appVersion = "2.0.0"
+
+func runCLI(engine *cleaner.Engine, cfg *config.Config, stdin *bufio.Reader,
+	discover *bool, clean *string, cleanAll *bool, noConfirm *bool, dryRun *bool, review *bool) {
 
-	fmt.Println("🧹 Cursor & Windsurf Data Cleaner v2.0.0 (Go)")
-	fmt.Println(strings.Repeat("=", 55))
-	fmt.Println("⚠️  IMPORTANT: This tool will modify application data.")
-	fmt.Println("   Always backup your important work before proceeding.")
-	fmt.Println("   Use this tool responsibly and in accordance with application ToS.")
-	fmt.Println()
+	fmt.Fprintln(output.text, "🧹 Cursor & Windsurf Data Cleaner v2.0.0 (Go)")
+	fmt.Fprintln(output.text, strings.Repeat("=", 55))
+	fmt.Fprintln(output.text, "⚠️  IMPORTANT: This tool will modify application data.")
+	fmt.Fprintln(output.text, "   Always backup your important work before proceeding.")
+	fmt.Fprintln(output.text, "   Use this tool responsibly and in accordance with application ToS.")
+	fmt.Fprintln(output.text)
 
 	if *discover {
-		performDiscovery(engine, cfg)
+		apps := performDiscovery(engine, cfg)
+		finish(exitOK, "", discoveryOutput{Apps: apps, BackupDirectory: engine.GetBackupDirectory()})
 		return
 	}
 
@@ -119,8 +233,7 @@ func runCLI(engine *cleaner.Engine, cfg *config.Config,
 	}
 
 	if len(availableApps) == 0 {
-		fmt.Println("❌ No supported applications found.")
-		os.Exit(1)
+		fail(exitFailure, "No supported applications found.")
 	}
 
 	var appsToClean []string
@@ -134,112 +247,810 @@ func runCLI(engine *cleaner.Engine, cfg *config.Config,
 			}
 		}
 		if !found {
-			fmt.Printf("❌ Application '%s' not found or not supported.\n", *clean)
-			os.Exit(1)
+			fail(exitFailure, "Application '%s' not found or not supported.", *clean)
 		}
 	} else if *cleanAll {
 		appsToClean = availableApps
 	} else {
 		performDiscovery(engine, cfg)
-		fmt.Println("\nAvailable applications to clean:")
+		fmt.Fprintln(output.text, "\nAvailable applications to clean:")
 		for i, app := range availableApps {
 			appConfig := cfg.Applications[app]
 			displayName := appConfig.DisplayName
-			fmt.Printf("  %d. %s\n", i+1, displayName)
+			fmt.Fprintf(output.text, "  %d. %s\n", i+1, displayName)
 		}
-		fmt.Println("  0. Exit")
+		fmt.Fprintln(output.text, "  0. Exit")
 
-		fmt.Print("\nSelect application to clean (number): ")
-		var choice int
-		fmt.Scanf("%d", &choice)
+		fmt.Fprint(output.text, "\nSelect application to clean (number): ")
+		choice, _ := strconv.Atoi(readAnswer(stdin))
 
 		if choice == 0 {
+			finish(exitOK, "", cleanOutput{DryRun: *dryRun, Apps: []appOutput{}})
 			return
 		}
 
 		if choice > 0 && choice <= len(availableApps) {
 			appsToClean = []string{availableApps[choice-1]}
 		} else {
-			fmt.Println("❌ Invalid choice.")
-			os.Exit(1)
+			fail(exitFailure, "Invalid choice.")
 		}
 	}
 
-	if !*noConfirm {
+	if *dryRun {
+		fmt.Fprintln(output.text, "🔍 Dry run: no files will be modified and no backups will be created.")
+	} else if err := engine.CheckBackupDirectory(); err != nil {
+		// 在询问确认之前检查备份目录，避免在没有备份的情况下修改数据
+		fail(exitFailure, "%v", err)
+	}
+
+	// -review 中的确认代替整体确认
+	if !*noConfirm && !*dryRun && !*review {
 		safetyOptions := cfg.SafetyOptions
 		if safetyOptions.RequireConfirmation {
-			fmt.Printf("\n⚠️  You are about to clean data for: %s\n", appsToClean[0])
-			fmt.Println("This will:")
-			fmt.Println("  • Reset machine/device IDs")
-			fmt.Println("  • Clear account-specific database records")
-			fmt.Println("  • Remove cached workspace data")
-			fmt.Println("  • Create backups of all modified files")
-
-			fmt.Print("\nAre you sure you want to proceed? (type 'yes' to confirm): ")
-			var confirm string
-			fmt.Scanf("%s", &confirm)
-			if confirm != "yes" {
-				fmt.Println("Operation cancelled.")
+			fmt.Fprintf(output.text, "\n⚠️  You are about to clean data for: %s\n", appsToClean[0])
+			fmt.Fprintln(output.text, "This will:")
+			for _, phase := range engine.EnabledPhases(appsToClean[0]) {
+				fmt.Fprintf(output.text, "  • %s\n", phaseSummary(phase))
+			}
+			fmt.Fprintln(output.text, "  • Create backups of all modified files")
+
+			fmt.Fprint(output.text, "\nAre you sure you want to proceed? (type 'yes' to confirm): ")
+			if readAnswer(stdin) != "yes" {
+				declined()
 				return
 			}
 		}
 	}
 
-	overallSuccess := true
+	if cfg.BackupOptions.BudgetPolicy == config.BudgetPolicyAsk && !*noConfirm {
+		engine.SetBudgetPrompt(promptBackupBudget(engine, stdin))
+	}
+
+	report := cleanOutput{DryRun: *dryRun}
+	skippedApps := 0
+	var results []*cleaner.CleanResult
+	var cleanedApps []string
 	for _, appName := range appsToClean {
-		fmt.Printf("\n🧹 Starting cleanup for %s...\n", appName)
+		fmt.Fprintf(output.text, "\n🧹 Starting cleanup for %s...\n", appName)
 
 		if engine.IsAppRunning(appName) {
-			fmt.Printf("❌ %s is currently running. Please close it first.\n", appName)
-			overallSuccess = false
+			fmt.Fprintf(output.text, "❌ %s is currently running. Please close it first.\n", appName)
+			report.add(appOutput{App: appName, Status: statusFailed, Error: appName + " is currently running"})
+			continue
+		}
+
+		var result *cleaner.CleanResult
+		var err error
+		if *review {
+			var plan *cleaner.Plan
+			if plan, err = engine.PlanApplication(appName); err == nil {
+				if plan = reviewPlan(engine, plan, stdin); plan == nil {
+					fmt.Fprintf(output.text, "⏭️  Skipped %s\n", appName)
+					report.add(appOutput{App: appName, Status: statusSkipped})
+					skippedApps++
+					continue
+				}
+				result, err = runCleanup(engine, func(ctx context.Context) (*cleaner.CleanResult, error) {
+					return engine.ApplyPlan(ctx, plan)
+				})
+			}
+		} else {
+			result, err = runCleanup(engine, func(ctx context.Context) (*cleaner.CleanResult, error) {
+				return engine.CleanApplication(ctx, appName)
+			})
+		}
+		app := newAppOutput(appName, result, err)
+		report.add(app)
+		if printRollback(engine, err) {
+			if app.Status == statusCancelled {
+				break
+			}
+			continue
+		}
+		if app.Status == statusCancelled {
+			printCancelled(engine, appName, err)
+			break
+		}
+		if result != nil {
+			printCleanResult(engine, result)
+			results = append(results, result)
+		}
+		switch {
+		case err != nil && result != nil:
+			fmt.Fprintf(output.text, "⚠️  %s was only partly cleaned:\n", appName)
+			printResultErrors(err)
+		case err != nil:
+			fmt.Fprintf(output.text, "❌ Failed to clean %s: %v\n", appName, err)
+		case *dryRun:
+			fmt.Fprintf(output.text, "✅ Dry run finished for %s, see the log above for what would change\n", appName)
+		default:
+			fmt.Fprintf(output.text, "✅ Successfully cleaned %s\n", appName)
+			cleanedApps = append(cleanedApps, appName)
+		}
+		if result != nil && result.SessionID != "" {
+			fmt.Fprintf(output.text, "🗂️  Backup session: %s (undo with -restore %s)\n", result.SessionID, result.SessionID)
+		}
+	}
+
+	code := report.exitCode()
+	fmt.Fprintln(output.text, "\n===== Cleaning Summary =====")
+	switch {
+	case code == exitCancelled:
+		fmt.Fprintln(output.text, "⏹️  Cleanup was cancelled, remaining applications were not touched.")
+	case skippedApps == len(appsToClean):
+		fmt.Fprintln(output.text, "Nothing was cleaned.")
+	default:
+		printResultTotals(engine, results)
+		switch {
+		case *dryRun && code == exitOK:
+			fmt.Fprintln(output.text, "✅ Dry run completed. No files were modified.")
+		case *dryRun:
+			fmt.Fprintln(output.text, "⚠️  Dry run completed with some errors. No files were modified.")
+		case code == exitOK:
+			fmt.Fprintf(output.text, "✅ Successfully cleaned data for: %s\n", strings.Join(cleanedApps, ", "))
+			fmt.Fprintf(output.text, "📁 Backups saved to: %s\n", engine.GetBackupDirectory())
+			fmt.Fprintln(output.text, "\nYou can now launch the applications and log in with different accounts.")
+		default:
+			fmt.Fprintln(output.text, "⚠️  Cleanup completed with some errors. Check the log for details.")
+			fmt.Fprintf(output.text, "📁 Backups saved to: %s\n", engine.GetBackupDirectory())
+		}
+	}
+	finish(code, "", report)
+}
+
+// printCleanResult lists what each phase of a reset did
+func printCleanResult(engine *cleaner.Engine, result *cleaner.CleanResult) {
+	for _, phase := range result.Phases {
+		var parts []string
+		if phase.FilesProcessed > 0 {
+			parts = append(parts, fmt.Sprintf("%d file(s)", phase.FilesProcessed))
+		}
+		if phase.KeysUpdated > 0 || phase.KeysDeleted > 0 {
+			parts = append(parts, fmt.Sprintf("%d key(s) updated, %d deleted", phase.KeysUpdated, phase.KeysDeleted))
+		}
+		if phase.RowsRemoved > 0 || phase.ValuesCleared > 0 {
+			parts = append(parts, fmt.Sprintf("%d row(s) removed, %d value(s) cleared", phase.RowsRemoved, phase.ValuesCleared))
+		}
+		if phase.DirsCleared > 0 {
+			parts = append(parts, fmt.Sprintf("%d director(ies) cleared, %s freed", phase.DirsCleared, engine.FormatSize(phase.BytesFreed)))
+		}
+		if len(phase.Failures) > 0 {
+			parts = append(parts, fmt.Sprintf("%d failure(s)", len(phase.Failures)))
+		}
+		if len(parts) == 0 {
+			parts = append(parts, "nothing to do")
+		}
+
+		mark := "✅"
+		if phase.Failed() {
+			mark = "❌"
+		}
+		fmt.Fprintf(output.text, "  %s %-10s %s\n", mark, phase.Name, strings.Join(parts, ", "))
+	}
+
+	if report := engine.GenerateCacheCleaningReport(result); report != "" {
+		fmt.Fprint(output.text, report)
+	}
+}
+
+// printResultErrors lists the failed phases of a combined error returned with a CleanResult
+func printResultErrors(err error) {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		fmt.Fprintf(output.text, "   • %v\n", err)
+		return
+	}
+	for _, phaseErr := range joined.Unwrap() {
+		fmt.Fprintf(output.text, "   • %v\n", phaseErr)
+	}
+}
+
+// printResultTotals adds up the results of all cleaned applications
+func printResultTotals(engine *cleaner.Engine, results []*cleaner.CleanResult) {
+	var total cleaner.PhaseResult
+	for _, result := range results {
+		for _, phase := range result.Phases {
+			total.FilesProcessed += phase.FilesProcessed
+			total.KeysUpdated += phase.KeysUpdated
+			total.KeysDeleted += phase.KeysDeleted
+			total.RowsRemoved += phase.RowsRemoved
+			total.ValuesCleared += phase.ValuesCleared
+			total.DirsCleared += phase.DirsCleared
+			total.BytesFreed += phase.BytesFreed
+			total.Failures = append(total.Failures, phase.Failures...)
+		}
+	}
+
+	fmt.Fprintf(output.text, "Files processed: %d, keys updated: %d, keys deleted: %d\n", total.FilesProcessed, total.KeysUpdated, total.KeysDeleted)
+	fmt.Fprintf(output.text, "Database rows removed: %d, values cleared: %d\n", total.RowsRemoved, total.ValuesCleared)
+	fmt.Fprintf(output.text, "Cache directories cleared: %d, space freed: %s\n", total.DirsCleared, engine.FormatSize(total.BytesFreed))
+	if len(total.Failures) > 0 {
+		fmt.Fprintf(output.text, "Failed items: %d\n", len(total.Failures))
+	}
+}
+
+// phaseSummary describes what a phase does for the confirmation prompt
+func phaseSummary(phase string) string {
+	switch phase {
+	case cleaner.PhaseTelemetry:
+		return "Reset machine/device IDs"
+	case cleaner.PhaseDatabase:
+		return "Clear account-specific database records"
+	case cleaner.PhaseCache:
+		return "Remove cached workspace data"
+	}
+	return "Run the " + phase + " phase"
+}
+
+// parsePhaseList splits the comma-separated -phases value
+func parsePhaseList(value string) []string {
+	var names []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// runCleanup runs reset with a context cancelled by Ctrl+C and streams its progress with -output ndjson
+func runCleanup(engine *cleaner.Engine, reset func(context.Context) (*cleaner.CleanResult, error)) (*cleaner.CleanResult, error) {
+	ctx, stop := interruptContext()
+	defer stop()
+	stopProgress := streamProgress(engine)
+	defer stopProgress()
+	return reset(ctx)
+}
+
+// interruptContext returns a context cancelled by Ctrl+C. 第一次 Ctrl+C 在当前文件处理完后停止，第二次立即退出
+func interruptContext() (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	stopped := make(chan struct{})
+	signal.Notify(signals, os.Interrupt)
+
+	go func() {
+		select {
+		case <-signals:
+		case <-stopped:
+			return
+		}
+		fmt.Fprintln(output.text, "\n⏹️  Cancelling after the current file, press Ctrl+C again to quit immediately...")
+		cancel()
+
+		select {
+		case <-signals:
+			os.Exit(exitCancelled)
+		case <-stopped:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(stopped)
+		cancel()
+	}
+}
+
+// printCancelled reports what a cancelled cleanup had already completed
+func printCancelled(engine *cleaner.Engine, appName string, err error) {
+	var cancelled *cleaner.CancelledError
+	if !errors.As(err, &cancelled) {
+		fmt.Fprintf(output.text, "⏹️  Cleanup of %s was cancelled before anything was modified\n", appName)
+		return
+	}
+
+	fmt.Fprintf(output.text, "⏹️  Cleanup of %s was cancelled during the %s phase\n", appName, cancelled.Phase)
+	if len(cancelled.Done) == 0 {
+		fmt.Fprintln(output.text, "   No file or directory had been completed yet.")
+	} else {
+		fmt.Fprintf(output.text, "   Completed before stopping (%d):\n", len(cancelled.Done))
+		for _, path := range cancelled.Done {
+			fmt.Fprintf(output.text, "     • %s\n", path)
+		}
+	}
+	fmt.Fprintln(output.text, "   Databases and identifier files not listed were left unchanged; a cache directory being cleared may be partially cleared.")
+	if sessionID := engine.GetLastSessionID(); sessionID != "" {
+		fmt.Fprintf(output.text, "🗂️  Backup session: %s (undo with -restore %s)\n", sessionID, sessionID)
+	}
+}
+
+// printRollback reports what a transactional run put back and returns false if err is not a rollback
+func printRollback(engine *cleaner.Engine, err error) bool {
+	var rollback *cleaner.RollbackError
+	if !errors.As(err, &rollback) {
+		return false
+	}
+
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintf(output.text, "⏹️  Cleanup of %s was cancelled, rolling back its changes\n", rollback.AppName)
+	} else {
+		fmt.Fprintf(output.text, "❌ Cleanup of %s failed: %v\n", rollback.AppName, rollback.Cause)
+	}
+
+	if len(rollback.Restored)+len(rollback.Failed)+len(rollback.NoBackup) == 0 {
+		fmt.Fprintln(output.text, "↩️  Nothing had been changed, nothing to roll back.")
+		return true
+	}
+
+	fmt.Fprintf(output.text, "↩️  Rolled back %d item(s):\n", len(rollback.Restored))
+	for _, path := range rollback.Restored {
+		fmt.Fprintf(output.text, "     • %s\n", path)
+	}
+	if len(rollback.Failed) > 0 {
+		fmt.Fprintf(output.text, "❌ Could not restore %d item(s), see the log:\n", len(rollback.Failed))
+		for _, path := range rollback.Failed {
+			fmt.Fprintf(output.text, "     • %s\n", path)
+		}
+	}
+	if len(rollback.NoBackup) > 0 {
+		fmt.Fprintf(output.text, "⚠️  Changed without a backup, left as they are (%d):\n", len(rollback.NoBackup))
+		for _, path := range rollback.NoBackup {
+			fmt.Fprintf(output.text, "     • %s\n", path)
+		}
+	}
+	if sessionID := engine.GetLastSessionID(); sessionID != "" {
+		fmt.Fprintf(output.text, "🗂️  Backup session: %s\n", sessionID)
+	}
+	return true
+}
+
+// promptBackupBudget asks on the console whether to continue when backups exceed the budget
+func promptBackupBudget(engine *cleaner.Engine, stdin *bufio.Reader) func(cleaner.BackupBudget) bool {
+	return func(budget cleaner.BackupBudget) bool {
+		free := "unknown"
+		if budget.Free >= 0 {
+			free = engine.FormatSize(budget.Free)
+		}
+
+		fmt.Fprintf(output.text, "\n⚠️  Backups for the %s phase of %s need %s (already used %s, limit %s, free %s).\n",
+			budget.Phase, budget.AppName, engine.FormatSize(budget.Estimated), engine.FormatSize(budget.Used), engine.FormatSize(budget.Limit), free)
+		if budget.Phase == cleaner.PhaseCache {
+			fmt.Fprint(output.text, "Clear the cache directories without backing them up? (type 'yes' to confirm): ")
+		} else {
+			fmt.Fprint(output.text, "Continue and exceed the backup size limit? (type 'yes' to confirm): ")
+		}
+
+		return readAnswer(stdin) == "yes"
+	}
+}
+
+func runRestore(engine *cleaner.Engine, cfg *config.Config, stdin *bufio.Reader, sessionID string, noConfirm bool) {
+	fmt.Fprintln(output.text, "♻️  Cursor & Windsurf Data Cleaner v2.0.0 (Go) - Restore")
+	fmt.Fprintln(output.text, strings.Repeat("=", 55))
+
+	if !noConfirm && cfg.SafetyOptions.RequireConfirmation {
+		fmt.Fprintf(output.text, "\n⚠️  You are about to restore backup session: %s\n", sessionID)
+		fmt.Fprintln(output.text, "This will overwrite the current application data with the backed-up files.")
+
+		fmt.Fprint(output.text, "\nAre you sure you want to proceed? (type 'yes' to confirm): ")
+		if readAnswer(stdin) != "yes" {
+			declined()
+			return
+		}
+	}
+
+	if err := engine.Restore(sessionID); err != nil {
+		fail(exitFailure, "Failed to restore %s: %v", sessionID, err)
+	}
+
+	fmt.Fprintf(output.text, "✅ Successfully restored backup session %s\n", sessionID)
+	finish(exitOK, "", map[string]string{"session_id": sessionID})
+}
+
+// backupPassphraseEnv 未指定 -passphrase-file 时从该环境变量读取备份密码
+const backupPassphraseEnv = "CWR_BACKUP_PASSPHRASE"
+
+// readBackupPassphrase reads the backup passphrase from path, or from the environment if path is empty.
+// 不提供命令行参数形式，避免密码出现在进程列表和 shell 历史中
+func readBackupPassphrase(path string) (string, error) {
+	if path == "" {
+		return os.Getenv(backupPassphraseEnv), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// confirmAction asks for a typed "yes" when the configuration requires confirmation
+func confirmAction(cfg *config.Config, stdin *bufio.Reader, noConfirm bool) bool {
+	if noConfirm || !cfg.SafetyOptions.RequireConfirmation {
+		return true
+	}
+
+	fmt.Fprint(output.text, "\nAre you sure you want to proceed? (type 'yes' to confirm): ")
+	return readAnswer(stdin) == "yes"
+}
+
+// readAnswer reads a line from stdin and returns its first word, 与之前的 fmt.Scanf("%s") 相同
+func readAnswer(stdin *bufio.Reader) string {
+	line, _ := stdin.ReadString('\n')
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+func printBackupSessions(engine *cleaner.Engine, sessions []cleaner.BackupSessionInfo) {
+	fmt.Fprintf(output.text, "%-28s %-12s %-20s %6s %10s %10s\n", "SESSION", "APP", "CREATED", "ITEMS", "SIZE", "ON DISK")
+	for _, session := range sessions {
+		fmt.Fprintf(output.text, "%-28s %-12s %-20s %6d %10s %10s\n",
+			session.ID, session.AppName, session.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+			session.Items, engine.FormatSize(session.Size), engine.FormatSize(session.DiskSize))
+	}
+}
+
+func listBackupSessions(engine *cleaner.Engine) {
+	sessions, err := engine.ListBackupSessions()
+	if err != nil {
+		fail(exitFailure, "Failed to list backup sessions: %v", err)
+	}
+
+	report := backupListOutput{BackupDirectory: engine.GetBackupDirectory(), Sessions: []cleaner.BackupSessionInfo{}}
+	fmt.Fprintf(output.text, "📁 Backup directory: %s\n\n", report.BackupDirectory)
+	if len(sessions) == 0 {
+		fmt.Fprintln(output.text, "No backup sessions found.")
+		finish(exitOK, "", report)
+		return
+	}
+
+	report.Sessions = sessions
+	report.DiskSize = engine.GetDirectorySize(report.BackupDirectory)
+	printBackupSessions(engine, sessions)
+	fmt.Fprintf(output.text, "\n%d session(s), %s on disk in total\n", len(sessions), engine.FormatSize(report.DiskSize))
+	finish(exitOK, "", report)
+}
+
+func inspectBackupSession(engine *cleaner.Engine, sessionID string) {
+	manifest, err := engine.InspectBackupSession(sessionID)
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	}
+
+	fmt.Fprintf(output.text, "🗂️  Backup session: %s\n", manifest.SessionID)
+	fmt.Fprintf(output.text, "   Application: %s\n", manifest.AppName)
+	fmt.Fprintf(output.text, "   Created: %s\n", manifest.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	if manifest.Encryption != nil {
+		fmt.Fprintf(output.text, "   Encryption: %s (%s)\n", manifest.Encryption.Cipher, manifest.Encryption.KDF)
+	}
+	fmt.Fprintf(output.text, "   Items: %d\n\n", len(manifest.Items))
+
+	for _, item := range manifest.Items {
+		kind := "file"
+		if item.IsDir {
+			kind = "dir"
+		}
+
+		fmt.Fprintf(output.text, "  [%s] %s (%s, %s, %s)\n", item.Phase, item.SourcePath, kind, item.BackupFormat(), engine.FormatSize(item.Size))
+		if item.BackupPath != "" {
+			fmt.Fprintf(output.text, "      backup: %s\n", item.BackupPath)
+		}
+		if item.SHA256 != "" {
+			fmt.Fprintf(output.text, "      sha256: %s\n", item.SHA256)
+		}
+	}
+	finish(exitOK, "", manifest)
+}
+
+func diffBackupSession(engine *cleaner.Engine, sessionID string) {
+	diff, err := engine.DiffSession(sessionID)
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	}
+
+	fmt.Fprintf(output.text, "🔍 Changes since backup session %s (%s)\n", diff.SessionID, diff.AppName)
+
+	changed := 0
+	for _, item := range diff.Items {
+		switch {
+		case item.Err != nil:
+			fmt.Fprintf(output.text, "\n❌ [%s] %s: %v\n", item.Phase, item.SourcePath, item.Err)
+			continue
+		case item.Missing:
+			fmt.Fprintf(output.text, "\n🗑️  [%s] %s (%s): no longer exists\n", item.Phase, item.SourcePath, item.Type)
+			changed++
+			continue
+		case len(item.Entries) == 0:
 			continue
 		}
 
-		err := engine.CleanApplication(context.Background(), appName)
-		if err != nil {
-			fmt.Printf("❌ Failed to clean %s: %v\n", appName, err)
-			overallSuccess = false
+		changed++
+		fmt.Fprintf(output.text, "\n📄 [%s] %s (%s, %d change(s))\n", item.Phase, item.SourcePath, item.Type, len(item.Entries))
+		for _, entry := range item.Entries {
+			switch entry.Kind {
+			case cleaner.DiffAdded:
+				fmt.Fprintf(output.text, "   + %s%s\n", entry.Key, diffValue(entry.After))
+			case cleaner.DiffRemoved:
+				fmt.Fprintf(output.text, "   - %s%s\n", entry.Key, diffValue(entry.Before))
+			default:
+				if entry.Before == "" && entry.After == "" {
+					fmt.Fprintf(output.text, "   ~ %s\n", entry.Key)
+				} else {
+					fmt.Fprintf(output.text, "   ~ %s: %s → %s\n", entry.Key, entry.Before, entry.After)
+				}
+			}
+		}
+	}
+
+	if changed == 0 {
+		fmt.Fprintln(output.text, "\n✅ No differences, the current files match the backup")
+	} else {
+		fmt.Fprintf(output.text, "\n%d of %d backed-up item(s) differ\n", changed, len(diff.Items))
+	}
+	finish(exitOK, "", diff)
+}
+
+func diffValue(value string) string {
+	if value == "" {
+		return ""
+	}
+	return ": " + value
+}
+
+func deleteBackupSession(engine *cleaner.Engine, cfg *config.Config, stdin *bufio.Reader, sessionID string, noConfirm bool) {
+	manifest, err := engine.InspectBackupSession(sessionID)
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	}
+
+	fmt.Fprintf(output.text, "⚠️  You are about to delete backup session %s of %s (%d items).\n", manifest.SessionID, manifest.AppName, len(manifest.Items))
+	if !confirmAction(cfg, stdin, noConfirm) {
+		declined()
+		return
+	}
+
+	if err := engine.DeleteBackupSession(sessionID); err != nil {
+		fail(exitFailure, "Failed to delete %s: %v", sessionID, err)
+	}
+
+	fmt.Fprintf(output.text, "✅ Deleted backup session %s\n", sessionID)
+	finish(exitOK, "", map[string]string{"session_id": manifest.SessionID})
+}
+
+func pruneBackupSessions(engine *cleaner.Engine, cfg *config.Config, stdin *bufio.Reader, opts cleaner.PruneOptions, noConfirm bool) {
+	if opts.KeepLast <= 0 && opts.MaxSize <= 0 && opts.OlderThan <= 0 {
+		fail(exitUsage, "Specify at least one of -keep-last, -max-backup-size-mb or -older-than-days.")
+	}
+
+	sessions, err := engine.SelectSessionsToPrune(opts)
+	if err != nil {
+		fail(exitFailure, "Failed to list backup sessions: %v", err)
+	}
+	if len(sessions) == 0 {
+		fmt.Fprintln(output.text, "Nothing to prune.")
+		finish(exitOK, "", backupListOutput{BackupDirectory: engine.GetBackupDirectory(), Sessions: []cleaner.BackupSessionInfo{}})
+		return
+	}
+
+	fmt.Fprintf(output.text, "⚠️  The following %d backup session(s) will be deleted:\n\n", len(sessions))
+	printBackupSessions(engine, sessions)
+	if !confirmAction(cfg, stdin, noConfirm) {
+		declined()
+		return
+	}
+
+	removed, err := engine.PruneBackupSessions(opts)
+	if err != nil {
+		fail(exitFailure, "Failed to prune backups: %v", err)
+	}
+
+	// sessions 为删除的会话，disk_size 为清理后备份目录的大小
+	report := backupListOutput{BackupDirectory: engine.GetBackupDirectory(), Sessions: removed}
+	report.DiskSize = engine.GetDirectorySize(report.BackupDirectory)
+	fmt.Fprintf(output.text, "✅ Removed %d backup session(s), %s now on disk\n", len(removed), engine.FormatSize(report.DiskSize))
+	finish(exitOK, "", report)
+}
+
+// printPlan lists the actions of a plan grouped by phase
+func printPlan(engine *cleaner.Engine, plan *cleaner.Plan) {
+	fmt.Fprintf(output.text, "📋 Plan for %s (%s), created %s\n", plan.AppName, plan.AppPath, plan.CreatedAt.Local().Format("2006-01-02 15:04:05"))
+	for _, phase := range plan.PhaseNames() {
+		actions := plan.PhaseActions(phase)
+		fmt.Fprintf(output.text, "\n%s (%d actions)\n", phase, len(actions))
+		for _, action := range actions {
+			line := action.Description()
+			if action.Size > 0 {
+				line += " [" + engine.FormatSize(action.Size) + "]"
+			}
+			fmt.Fprintf(output.text, "  %4d. %s\n", action.ID, line)
+		}
+	}
+}
+
+func estimateDatabaseCleaning(engine *cleaner.Engine, appName string) {
+	if appName == "" {
+		fail(exitUsage, "-estimate requires -clean <app>.")
+	}
+
+	plan, err := engine.PlanApplication(appName)
+	if err != nil {
+		fail(exitFailure, "Failed to estimate cleanup of %s: %v", appName, err)
+	}
+
+	fmt.Fprintf(output.text, "🔎 Estimated database changes for %s (nothing has been deleted)\n", appName)
+
+	report := estimateOutput{App: appName, Actions: []cleaner.Action{}}
+	currentPath := ""
+	for _, action := range plan.PhaseActions(cleaner.PhaseDatabase) {
+		if action.Type != cleaner.ActionDeleteRows && action.Type != cleaner.ActionClearColumn {
+			continue
+		}
+		if action.Path != currentPath {
+			currentPath = action.Path
+			fmt.Fprintf(output.text, "\n%s\n", currentPath)
+		}
+		report.Actions = append(report.Actions, action)
+		report.TotalRows += action.Count
+
+		if action.Type == cleaner.ActionClearColumn {
+			fmt.Fprintf(output.text, "  %s: clear %s in %d rows\n", action.Table, action.Column, action.Count)
+			continue
+		}
+		if len(action.Columns) == 0 {
+			fmt.Fprintf(output.text, "  %s: all %d rows (cache table)\n", action.Table, action.Count)
 		} else {
-			fmt.Printf("✅ Successfully cleaned %s\n", appName)
+			fmt.Fprintf(output.text, "  %s: %d rows\n", action.Table, action.Count)
+		}
+		for _, estimate := range action.Estimates {
+			match := "all rows"
+			if estimate.Column != "" {
+				match = fmt.Sprintf("%s LIKE '%%%s%%'", estimate.Column, estimate.Keyword)
+			}
+			fmt.Fprintf(output.text, "    %-40s %8d rows", match, estimate.Rows)
+			if len(estimate.SampleKeys) > 0 {
+				fmt.Fprintf(output.text, "  e.g. %s", strings.Join(estimate.SampleKeys, ", "))
+			}
+			fmt.Fprintln(output.text)
 		}
 	}
 
-	fmt.Println("\n===== Cleaning Summary =====")
-	if overallSuccess {
-		fmt.Printf("✅ Successfully cleaned data for: %s\n", appsToClean[0])
-		fmt.Printf("📁 Backups saved to: %s\n", engine.GetBackupDirectory())
-		fmt.Println("\nYou can now launch the applications and log in with different accounts.")
+	if currentPath == "" {
+		fmt.Fprintln(output.text, "\nNo database rows would be removed.")
 	} else {
-		fmt.Println("⚠️  Cleanup completed with some errors. Check the log for details.")
-		fmt.Printf("📁 Backups saved to: %s\n", engine.GetBackupDirectory())
+		fmt.Fprintf(output.text, "\n%d rows in total. A row matching several keywords is listed under each of them.\n", report.TotalRows)
 	}
+	finish(exitOK, "", report)
 }
 
-func performDiscovery(engine *cleaner.Engine, cfg *config.Config) {
-	fmt.Println("=== Application Data Discovery ===")
+func writeCleaningPlan(engine *cleaner.Engine, appName, path string) {
+	if appName == "" {
+		fail(exitUsage, "-plan requires -clean <app>.")
+	}
+
+	plan, err := engine.PlanApplication(appName)
+	if err != nil {
+		fail(exitFailure, "Failed to plan cleanup of %s: %v", appName, err)
+	}
+
+	printPlan(engine, plan)
+
+	if err := cleaner.WritePlan(path, plan); err != nil {
+		fail(exitFailure, "Failed to write plan: %v", err)
+	}
+	fmt.Fprintf(output.text, "\n✅ Plan with %d actions written to %s (apply with -apply %s)\n", len(plan.Actions), path, path)
+	finish(exitOK, "", planOutput{Path: path, Plan: plan})
+}
+
+func applyCleaningPlan(engine *cleaner.Engine, cfg *config.Config, stdin *bufio.Reader, path string, phaseNames []string, noConfirm, dryRun, review bool) {
+	plan, err := cleaner.ReadPlan(path)
+	if err != nil {
+		fail(exitFailure, "Failed to read plan: %v", err)
+	}
+
+	report := cleanOutput{DryRun: dryRun, Apps: []appOutput{}}
+
+	// -phases 只执行计划中这些阶段的动作
+	if len(phaseNames) > 0 {
+		if plan = plan.OnlyPhases(phaseNames); len(plan.Actions) == 0 {
+			fmt.Fprintf(output.text, "Plan has no actions in phases %s, nothing to apply.\n", strings.Join(phaseNames, ", "))
+			finish(exitOK, "", report)
+			return
+		}
+	}
+
+	if !dryRun {
+		if err := engine.CheckBackupDirectory(); err != nil {
+			fail(exitFailure, "%v", err)
+		}
+	}
+
+	switch {
+	case review:
+		if plan = reviewPlan(engine, plan, stdin); plan == nil {
+			declined()
+			return
+		}
+	case dryRun:
+		printPlan(engine, plan)
+	default:
+		printPlan(engine, plan)
+		fmt.Fprintf(output.text, "\n⚠️  You are about to apply %d actions to %s.\n", len(plan.Actions), plan.AppName)
+		if !confirmAction(cfg, stdin, noConfirm) {
+			declined()
+			return
+		}
+	}
+
+	if dryRun {
+		fmt.Fprintln(output.text, "\n🔍 Dry run: no files will be modified and no backups will be created.")
+	}
+
+	if cfg.BackupOptions.BudgetPolicy == config.BudgetPolicyAsk && !noConfirm {
+		engine.SetBudgetPrompt(promptBackupBudget(engine, stdin))
+	}
+
+	result, err := runCleanup(engine, func(ctx context.Context) (*cleaner.CleanResult, error) {
+		return engine.ApplyPlan(ctx, plan)
+	})
+	app := newAppOutput(plan.AppName, result, err)
+	report.add(app)
+
+	switch {
+	case printRollback(engine, err):
+	case app.Status == statusCancelled:
+		printCancelled(engine, plan.AppName, err)
+	case result == nil:
+		fmt.Fprintf(output.text, "❌ Failed to apply plan: %v\n", err)
+	default:
+		printCleanResult(engine, result)
+		if result.SessionID != "" {
+			fmt.Fprintf(output.text, "🗂️  Backup session: %s (undo with -restore %s)\n", result.SessionID, result.SessionID)
+		}
+		switch {
+		case err != nil:
+			fmt.Fprintf(output.text, "⚠️  Plan was only partly applied to %s:\n", plan.AppName)
+			printResultErrors(err)
+		case dryRun:
+			fmt.Fprintf(output.text, "✅ Dry run finished for %s, see the log above for what would change\n", plan.AppName)
+		default:
+			fmt.Fprintf(output.text, "✅ Applied plan to %s\n", plan.AppName)
+		}
+	}
+	finish(report.exitCode(), "", report)
+}
+
+// performDiscovery reports where the data of each application is, in name order
+func performDiscovery(engine *cleaner.Engine, cfg *config.Config) []discoveredApp {
+	fmt.Fprintln(output.text, "=== Application Data Discovery ===")
 
 	appDataPaths := engine.GetAppDataPaths()
-	for appName, appPath := range appDataPaths {
+	appNames := make([]string, 0, len(appDataPaths))
+	for appName := range appDataPaths {
+		appNames = append(appNames, appName)
+	}
+	sort.Strings(appNames)
+
+	apps := make([]discoveredApp, 0, len(appNames))
+	for _, appName := range appNames {
+		appPath := appDataPaths[appName]
 		appConfig := cfg.Applications[appName]
 		displayName := appConfig.DisplayName
+		app := discoveredApp{Name: appName, DisplayName: displayName, Found: appPath != "", Path: appPath}
 
-		if appPath != "" {
-			fmt.Printf("%s: Found at %s\n", displayName, appPath)
+		if app.Found {
+			fmt.Fprintf(output.text, "%s: Found at %s\n", displayName, appPath)
 
-			if engine.IsAppRunning(appName) {
-				fmt.Printf("  %s is currently running\n", displayName)
+			app.Running = engine.IsAppRunning(appName)
+			if app.Running {
+				fmt.Fprintf(output.text, "  %s is currently running\n", displayName)
 			} else {
-				fmt.Printf("  %s is not running\n", displayName)
+				fmt.Fprintf(output.text, "  %s is not running\n", displayName)
 			}
 
-			size := engine.GetDirectorySize(appPath)
-			fmt.Printf("  💾 Size: %s\n", engine.FormatSize(size))
+			app.Size = engine.GetDirectorySize(appPath)
+			fmt.Fprintf(output.text, "  💾 Size: %s\n", engine.FormatSize(app.Size))
 		} else {
-			fmt.Printf("%s: Not found\n", displayName)
+			fmt.Fprintf(output.text, "%s: Not found\n", displayName)
 		}
+		apps = append(apps, app)
 	}
 
-	fmt.Printf("📁 Backup directory: %s\n", engine.GetBackupDirectory())
+	fmt.Fprintf(output.text, "📁 Backup directory: %s\n", engine.GetBackupDirectory())
+	return apps
 }
 
 func runGUI() {
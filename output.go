@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"Cursor_Windsurf_Reset/cleaner"
+	"github.com/rs/zerolog/log"
+)
+
+// Exit codes of the command line interface, the same for every -output format
+const (
+	exitOK        = 0   // the command succeeded, including dry runs
+	exitFailure   = 1   // the command failed or was not confirmed; 事务模式下本次修改已撤销
+	exitUsage     = 2   // invalid command line, 与 flag 包解析失败时相同
+	exitPartial   = 3   // some applications, phases or files failed and the rest was changed
+	exitCancelled = 130 // cancelled with Ctrl+C
+)
+
+// exitCodesHelp 附加在 -h 的输出之后
+const exitCodesHelp = `
+Exit codes:
+  0    success, including dry runs
+  1    failure, or the command was not confirmed (a transactional run has been rolled back)
+  2    invalid command line
+  3    partly done: some applications, phases or files failed, the rest was changed
+  130  cancelled with Ctrl+C
+`
+
+// Formats of -output
+const (
+	outputText   = "text"
+	outputJSON   = "json"
+	outputNDJSON = "ndjson"
+)
+
+// outputSchemaVersion is the schema_version of every JSON document and line.
+// 只会新增字段；删除、改名或改变字段含义时才递增
+const outputSchemaVersion = 1
+
+// Values of "status" in the result document and of the status of each application
+const (
+	statusSuccess    = "success"
+	statusPartial    = "partial"
+	statusFailed     = "failed"
+	statusCancelled  = "cancelled"
+	statusSkipped    = "skipped"
+	statusUsageError = "usage_error"
+)
+
+// cliOutput writes the machine-readable output of the running command.
+// JSON 格式下 stdout 只输出 JSON，面向用户的文本、确认提示和日志写入 text，即 stderr
+type cliOutput struct {
+	format  string
+	command string
+	out     io.Writer // JSON documents and lines
+	text    io.Writer // messages, prompts and logs meant for the user
+}
+
+var output = &cliOutput{format: outputText, out: os.Stdout, text: os.Stdout}
+
+// setupOutput selects the -output format of command
+func setupOutput(format, command string) error {
+	switch format {
+	case outputText:
+	case outputJSON, outputNDJSON:
+		output.text = os.Stderr
+	default:
+		return fmt.Errorf("unknown -output format %q, use text, json or ndjson", format)
+	}
+	output.format = format
+	output.command = command
+	return nil
+}
+
+func (o *cliOutput) machineReadable() bool {
+	return o.format != outputText
+}
+
+// outputResult is the document written by -output json and the last line written by -output ndjson
+type outputResult struct {
+	SchemaVersion int         `json:"schema_version"`
+	Type          string      `json:"type,omitempty"` // "result", only in ndjson
+	Command       string      `json:"command"`
+	Status        string      `json:"status"`
+	ExitCode      int         `json:"exit_code"`
+	Error         string      `json:"error,omitempty"` // why the command as a whole failed; 单个应用的错误在 data 中
+	Data          interface{} `json:"data,omitempty"`
+}
+
+// outputEvent is a line written by -output ndjson while the command runs
+type outputEvent struct {
+	SchemaVersion int         `json:"schema_version"`
+	Type          string      `json:"type"` // "progress" or "app_result"
+	Data          interface{} `json:"data"`
+}
+
+func (o *cliOutput) write(v interface{}) {
+	encoder := json.NewEncoder(o.out)
+	encoder.SetEscapeHTML(false)
+	if o.format == outputJSON {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(v); err != nil {
+		log.Error().Err(err).Msg("Failed to encode JSON output")
+	}
+}
+
+// event writes a line of -output ndjson; 其他格式不输出
+func (o *cliOutput) event(eventType string, data interface{}) {
+	if o.format == outputNDJSON {
+		o.write(outputEvent{SchemaVersion: outputSchemaVersion, Type: eventType, Data: data})
+	}
+}
+
+// exitStatus is the status reported together with an exit code
+func exitStatus(code int) string {
+	switch code {
+	case exitOK:
+		return statusSuccess
+	case exitUsage:
+		return statusUsageError
+	case exitPartial:
+		return statusPartial
+	case exitCancelled:
+		return statusCancelled
+	}
+	return statusFailed
+}
+
+// finish writes the result of the command and exits with code. 文本格式下只退出；code 为 exitOK 时返回
+func finish(code int, errMessage string, data interface{}) {
+	if output.machineReadable() {
+		result := outputResult{
+			SchemaVersion: outputSchemaVersion,
+			Command:       output.command,
+			Status:        exitStatus(code),
+			ExitCode:      code,
+			Error:         errMessage,
+			Data:          data,
+		}
+		if output.format == outputNDJSON {
+			result.Type = "result"
+		}
+		output.write(result)
+	}
+	if code != exitOK {
+		os.Exit(code)
+	}
+}
+
+// fail prints an error message and finishes the command with code
+func fail(code int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Fprintf(output.text, "❌ %s\n", message)
+	finish(code, message, nil)
+}
+
+// declined finishes a command the user did not confirm
+func declined() {
+	fmt.Fprintln(output.text, "Operation cancelled.")
+	finish(exitFailure, "operation was not confirmed", nil)
+}
+
+// streamProgress writes the engine's progress updates as "progress" lines of -output ndjson until the returned function is called
+func streamProgress(engine *cleaner.Engine) func() {
+	if output.format != outputNDJSON {
+		return func() {}
+	}
+
+	updates := engine.GetProgressChannel()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case update := <-updates:
+				output.event("progress", update)
+			case <-stop:
+				// 引擎同步发送进度，停止时通道中剩余的更新都已发送完毕
+				for {
+					select {
+					case update := <-updates:
+						output.event("progress", update)
+					default:
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// discoveredApp is an application in the data of the discover command
+type discoveredApp struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Found       bool   `json:"found"`
+	Path        string `json:"path,omitempty"`
+	Running     bool   `json:"running"`
+	Size        int64  `json:"size"`
+}
+
+// discoveryOutput is the data of the discover command
+type discoveryOutput struct {
+	Apps            []discoveredApp `json:"apps"`
+	BackupDirectory string          `json:"backup_directory"`
+}
+
+// cleanOutput is the data of the clean and apply commands
+type cleanOutput struct {
+	DryRun bool        `json:"dry_run"`
+	Apps   []appOutput `json:"apps"`
+}
+
+// appOutput is the outcome of resetting one application
+type appOutput struct {
+	App       string               `json:"app"`
+	Status    string               `json:"status"` // success, partial, failed, cancelled or skipped
+	Error     string               `json:"error,omitempty"`
+	Result    *cleaner.CleanResult `json:"result,omitempty"`
+	Completed []string             `json:"completed,omitempty"` // 取消前已完整处理的文件和目录
+	Rollback  *rollbackOutput      `json:"rollback,omitempty"`  // 事务模式下撤销的修改
+}
+
+// rollbackOutput lists what a transactional run put back, see cleaner.RollbackError
+type rollbackOutput struct {
+	Restored []string `json:"restored"`
+	Failed   []string `json:"failed"`
+	NoBackup []string `json:"no_backup"`
+}
+
+// newAppOutput describes the result and error returned by ApplyPlan or CleanApplication
+func newAppOutput(appName string, result *cleaner.CleanResult, err error) appOutput {
+	app := appOutput{App: appName, Status: statusSuccess, Result: result}
+	if err == nil {
+		return app
+	}
+
+	app.Error = err.Error()
+	var rollback *cleaner.RollbackError
+	if errors.As(err, &rollback) {
+		app.Rollback = &rollbackOutput{
+			Restored: append([]string{}, rollback.Restored...),
+			Failed:   append([]string{}, rollback.Failed...),
+			NoBackup: append([]string{}, rollback.NoBackup...),
+		}
+	}
+	var cancelled *cleaner.CancelledError
+	if errors.As(err, &cancelled) {
+		app.Completed = cancelled.Done
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		app.Status = statusCancelled
+	case result != nil && rollback == nil:
+		app.Status = statusPartial
+	default:
+		app.Status = statusFailed
+	}
+	return app
+}
+
+// add records the outcome of an application and streams it with -output ndjson
+func (c *cleanOutput) add(app appOutput) {
+	c.Apps = append(c.Apps, app)
+	output.event("app_result", app)
+}
+
+// exitCode 汇总所有应用的状态：有应用被取消时为 exitCancelled，部分成功时为 exitPartial
+func (c *cleanOutput) exitCode() int {
+	succeeded, failed := false, false
+	for _, app := range c.Apps {
+		switch app.Status {
+		case statusCancelled:
+			return exitCancelled
+		case statusSuccess:
+			succeeded = true
+		case statusPartial:
+			succeeded, failed = true, true
+		case statusFailed:
+			failed = true
+		}
+	}
+
+	switch {
+	case !failed:
+		return exitOK
+	case succeeded:
+		return exitPartial
+	}
+	return exitFailure
+}
+
+// backupListOutput is the data of the list-backups command
+type backupListOutput struct {
+	BackupDirectory string                      `json:"backup_directory"`
+	Sessions        []cleaner.BackupSessionInfo `json:"sessions"`
+	DiskSize        int64                       `json:"disk_size"`
+}
+
+// planOutput is the data of the plan command
+type planOutput struct {
+	Path string        `json:"path"`
+	Plan *cleaner.Plan `json:"plan"`
+}
+
+// estimateOutput is the data of the estimate command
+type estimateOutput struct {
+	App       string           `json:"app"`
+	Actions   []cleaner.Action `json:"actions"` // delete_rows and clear_column actions with their row estimates
+	TotalRows int64            `json:"total_rows"`
+}
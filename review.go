@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"Cursor_Windsurf_Reset/cleaner"
+)
+
+// reviewFile is one file or directory of a plan with the IDs of its actions
+type reviewFile struct {
+	phase   string
+	path    string
+	actions []cleaner.Action
+}
+
+// planReview keeps track of which actions of a plan the user has switched on
+type planReview struct {
+	engine  *cleaner.Engine
+	plan    *cleaner.Plan
+	files   []reviewFile
+	enabled map[int]bool
+}
+
+func newPlanReview(engine *cleaner.Engine, plan *cleaner.Plan) *planReview {
+	review := &planReview{engine: engine, plan: plan, enabled: make(map[int]bool)}
+
+	index := make(map[string]int)
+	for _, phase := range plan.PhaseNames() {
+		for _, action := range plan.PhaseActions(phase) {
+			review.enabled[action.ID] = true
+
+			key := phase + "\x00" + action.Path
+			i, exists := index[key]
+			if !exists {
+				i = len(review.files)
+				index[key] = i
+				review.files = append(review.files, reviewFile{phase: phase, path: action.Path})
+			}
+			review.files[i].actions = append(review.files[i].actions, action)
+		}
+	}
+	return review
+}
+
+// reviewPlan lets the user switch actions of plan on and off on the terminal.
+// 返回只包含启用动作的计划；用户取消或没有启用任何动作时返回 nil
+func reviewPlan(engine *cleaner.Engine, plan *cleaner.Plan, stdin *bufio.Reader) *cleaner.Plan {
+	review := newPlanReview(engine, plan)
+
+	review.print()
+	for {
+		fmt.Fprint(output.text, "\nreview> ")
+		line, err := stdin.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(output.text)
+			return nil
+		}
+
+		command := strings.TrimSpace(line)
+		switch {
+		case command == "":
+			continue
+		case command == "y" || command == "yes":
+			reviewed := plan.Filter(func(action cleaner.Action) bool { return review.enabled[action.ID] })
+			if len(reviewed.Actions) == 0 {
+				fmt.Fprintln(output.text, "No actions enabled, nothing to apply.")
+				return nil
+			}
+			return reviewed
+		case command == "q" || command == "quit":
+			return nil
+		case command == "l" || command == "list":
+			review.print()
+		case command == "h" || command == "help" || command == "?":
+			printReviewHelp()
+		case command == "a":
+			review.setAll(true)
+			review.print()
+		case command == "n":
+			review.setAll(false)
+			review.print()
+		default:
+			if err := review.toggle(command); err != nil {
+				fmt.Fprintf(output.text, "❌ %v (type h for help)\n", err)
+				continue
+			}
+			review.print()
+		}
+	}
+}
+
+func printReviewHelp() {
+	fmt.Fprintln(output.text, "Commands:")
+	fmt.Fprintln(output.text, "  3, 3-7, 2,4    switch single actions on or off")
+	fmt.Fprintln(output.text, "  f2             switch all actions of file 2 on or off")
+	fmt.Fprintln(output.text, "  p cache        switch a whole phase (telemetry, database, cache, ...) on or off")
+	fmt.Fprintln(output.text, "  a / n          switch all actions on / off")
+	fmt.Fprintln(output.text, "  l              list the plan again")
+	fmt.Fprintln(output.text, "  y              apply the actions that are on")
+	fmt.Fprintln(output.text, "  q              cancel")
+}
+
+func (r *planReview) print() {
+	fmt.Fprintf(output.text, "\n📋 Plan for %s: %d of %d actions on\n", r.plan.AppName, r.countEnabled(), len(r.plan.Actions))
+
+	for _, phase := range r.plan.PhaseNames() {
+		var phaseFiles []int
+		for i, file := range r.files {
+			if file.phase == phase {
+				phaseFiles = append(phaseFiles, i)
+			}
+		}
+
+		fmt.Fprintf(output.text, "\n%s %s\n", r.mark(r.phaseActions(phase)), strings.ToUpper(phase))
+		if len(phaseFiles) == 0 {
+			fmt.Fprintln(output.text, "    nothing to do")
+			continue
+		}
+
+		// files 已按阶段顺序排列，文件编号即其下标加一
+		for _, i := range phaseFiles {
+			file := r.files[i]
+
+			var size int64
+			for _, action := range file.actions {
+				if action.Size > size {
+					size = action.Size
+				}
+			}
+			sizeText := ""
+			if size > 0 {
+				sizeText = " (" + r.engine.FormatSize(size) + ")"
+			}
+			fmt.Fprintf(output.text, "  %s f%d %s%s\n", r.mark(file.actions), i+1, file.path, sizeText)
+
+			for _, action := range file.actions {
+				description := action.Description()
+				if action.Type == cleaner.ActionBackup {
+					description = "back up first"
+				} else if action.Type == cleaner.ActionClearDir {
+					description = "clear contents"
+				}
+				if action.Count > 0 && action.Type != cleaner.ActionDeleteRows && action.Type != cleaner.ActionClearColumn {
+					description += fmt.Sprintf(" (%d)", action.Count)
+				}
+				fmt.Fprintf(output.text, "      %s %4d. %s\n", checkbox(r.enabled[action.ID]), action.ID, description)
+			}
+
+			if r.missingBackup(file.actions) {
+				fmt.Fprintln(output.text, "           ⚠️  will be modified without a backup")
+			}
+		}
+	}
+	fmt.Fprintln(output.text, "\nType h for help, y to apply, q to cancel.")
+}
+
+func checkbox(on bool) string {
+	if on {
+		return "[x]"
+	}
+	return "[ ]"
+}
+
+// mark 全部启用为 [x]，部分启用为 [~]，全部关闭为 [ ]
+func (r *planReview) mark(actions []cleaner.Action) string {
+	on := 0
+	for _, action := range actions {
+		if r.enabled[action.ID] {
+			on++
+		}
+	}
+	switch {
+	case on == 0:
+		return "[ ]"
+	case on == len(actions):
+		return "[x]"
+	}
+	return "[~]"
+}
+
+// missingBackup reports whether changes of a file are on while its backup was switched off
+func (r *planReview) missingBackup(actions []cleaner.Action) bool {
+	hasBackup, backupOn, changeOn := false, false, false
+	for _, action := range actions {
+		if action.Type == cleaner.ActionBackup {
+			hasBackup = true
+			backupOn = backupOn || r.enabled[action.ID]
+		} else if r.enabled[action.ID] {
+			changeOn = true
+		}
+	}
+	return hasBackup && !backupOn && changeOn
+}
+
+func (r *planReview) countEnabled() int {
+	count := 0
+	for _, on := range r.enabled {
+		if on {
+			count++
+		}
+	}
+	return count
+}
+
+func (r *planReview) phaseActions(phase string) []cleaner.Action {
+	var actions []cleaner.Action
+	for _, file := range r.files {
+		if file.phase == phase {
+			actions = append(actions, file.actions...)
+		}
+	}
+	return actions
+}
+
+func (r *planReview) setAll(on bool) {
+	for id := range r.enabled {
+		r.enabled[id] = on
+	}
+}
+
+// toggleGroup 组内有任一动作启用时全部关闭，否则全部启用
+func (r *planReview) toggleGroup(actions []cleaner.Action) {
+	on := r.mark(actions) == "[ ]"
+	for _, action := range actions {
+		r.enabled[action.ID] = on
+	}
+}
+
+// toggle handles "f<n>", "p <phase>" and lists of action IDs and ranges
+func (r *planReview) toggle(command string) error {
+	if strings.HasPrefix(command, "p ") {
+		phase := strings.TrimSpace(strings.TrimPrefix(command, "p "))
+		actions := r.phaseActions(phase)
+		if len(actions) == 0 {
+			return fmt.Errorf("no actions in phase %q", phase)
+		}
+		r.toggleGroup(actions)
+		return nil
+	}
+
+	if strings.HasPrefix(command, "f") {
+		number, err := strconv.Atoi(strings.TrimPrefix(command, "f"))
+		if err != nil || number < 1 || number > len(r.files) {
+			return fmt.Errorf("unknown file %q", command)
+		}
+		r.toggleGroup(r.files[number-1].actions)
+		return nil
+	}
+
+	// 按 -phases 过滤后的计划保留原编号，上限取最大的编号
+	maxID := 0
+	for _, action := range r.plan.Actions {
+		if action.ID > maxID {
+			maxID = action.ID
+		}
+	}
+	ids, err := parseActionIDs(command, maxID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, exists := r.enabled[id]; !exists {
+			return fmt.Errorf("unknown action %d", id)
+		}
+	}
+	for _, id := range ids {
+		r.enabled[id] = !r.enabled[id]
+	}
+	return nil
+}
+
+// parseActionIDs parses "3", "3-7" and comma or space separated lists of both.
+// 编号必须在 1..maxID 之内，先检查范围再展开，避免 1-999999999 这样的输入占用大量内存
+func parseActionIDs(text string, maxID int) ([]int, error) {
+	var ids []int
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		from, to, isRange := strings.Cut(field, "-")
+		start, err := strconv.Atoi(from)
+		if err != nil {
+			return nil, fmt.Errorf("unknown command %q", field)
+		}
+		end := start
+		if isRange {
+			if end, err = strconv.Atoi(to); err != nil || end < start {
+				return nil, fmt.Errorf("invalid range %q", field)
+			}
+		}
+		if start < 1 || end > maxID {
+			return nil, fmt.Errorf("action %q out of range 1-%d", field, maxID)
+		}
+		for id := start; id <= end; id++ {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}